@@ -54,11 +54,98 @@ func NewAppSkeleton[P Payload, K DestKey](setup func(c *cli.Context, options *Op
 			Name:  "max-age",
 			Value: time.Minute * 15,
 		},
+		&cli.StringFlag{
+			Name:  "window",
+			Usage: "align blocks to hour, day, week, month or a duration from midnight in --window-location, instead of --max-age",
+		},
+		&cli.StringFlag{
+			Name:  "window-location",
+			Value: "Local",
+			Usage: "time zone of --window (e.g. Europe/Oslo)",
+		},
+		&cli.IntFlag{
+			Name:  "fetch-batch",
+			Usage: "messages per fetch. defaults to the consumer's max request batch, or 100",
+		},
+		&cli.IntFlag{
+			Name:  "fetch-max-bytes",
+			Usage: "limit the bytes per fetch",
+		},
+		&cli.DurationFlag{
+			Name:  "fetch-timeout",
+			Value: DefaultFetchTimeout,
+			Usage: "how long a fetch waits for messages",
+		},
+		&cli.BoolFlag{
+			Name:  "adaptive-fetch",
+			Usage: "grow or shrink the fetch batch (up to --fetch-batch) based on fetch latency and ack pending room",
+		},
+		&cli.DurationFlag{
+			Name:  "consumer-refresh",
+			Value: DefaultConsumerRefresh,
+			Usage: "how often the consumer config is read again to pick up changes",
+		},
+		&cli.DurationFlag{
+			Name:  "flush-grace",
+			Usage: "keep blocks open this long after their interval ends for out-of-order messages",
+		},
+		&cli.BoolFlag{
+			Name:  "idle-flush",
+			Usage: "finalize blocks based on wall-clock time, even if no newer messages arrive",
+		},
+		&cli.BoolFlag{
+			Name:  "exactly-once",
+			Usage: "give blocks deterministic names and drop redelivered messages, so a crash doesn't duplicate blocks",
+		},
+		&cli.StringFlag{
+			Name:  "late-policy",
+			Value: string(LateNewBlock),
+			Usage: `where late messages for expired intervals go: "new-block", "separate", "append" or "rewrite"`,
+		},
+		&cli.StringFlag{
+			Name:  "time-header",
+			Usage: "take the message time deciding its block from this header (e.g. Event-Time) instead of the stream",
+		},
+		&cli.StringFlag{
+			Name:  "time-layout",
+			Value: time.RFC3339Nano,
+			Usage: `layout of the time header: a Go time layout, "unix" or "unixmilli"`,
+		},
+		&cli.StringFlag{
+			Name:  "time-fallback",
+			Value: string(TimeFallbackStream),
+			Usage: `what to do with messages without a usable time header: "stream", "drop" or "fail"`,
+		},
+		&cli.DurationFlag{
+			Name:  "max-past-time",
+			Usage: "fall back for header times further than this before the stream time",
+		},
+		&cli.DurationFlag{
+			Name:  "max-future-time",
+			Usage: "fall back for header times further than this after the stream time",
+		},
 		&cli.BoolFlag{
 			Name:  "buffer-to-disk",
 			Value: true,
 			Usage: "buffer data to disk or memory",
 		},
+		&cli.Int64Flag{
+			Name:  "max-memory-bytes",
+			Usage: "limit the memory used by blocks buffered in memory (i.e. --buffer-to-disk=false)",
+		},
+		&cli.Int64Flag{
+			Name:  "max-spool-bytes",
+			Usage: "limit the size of the temp files of all blocks. the largest blocks are stored early past it",
+		},
+		&cli.Uint64Flag{
+			Name:  "min-free-spool-bytes",
+			Usage: "free space to keep in --tmp-dir. blocks are stored early, and fetching pauses, below it",
+		},
+		&cli.StringFlag{
+			Name:  "memory-policy",
+			Value: string(MemorySpill),
+			Usage: `what to do once --max-memory-bytes is exceeded: "spill" the largest blocks to disk, or "finalize" the oldest`,
+		},
 		&cli.PathFlag{
 			Name:  "tmp-dir",
 			Value: os.TempDir(),
@@ -69,6 +156,18 @@ func NewAppSkeleton[P Payload, K DestKey](setup func(c *cli.Context, options *Op
 			Value: string(None),
 			Usage: `choose from "none", "gzip", or "snappy"`,
 		},
+		&cli.PathFlag{
+			Name:  "encryption-key-file",
+			Usage: "encrypt blocks using the AES-256 key in this file (32 raw bytes, hex or base64)",
+		},
+		&cli.PathFlag{
+			Name:  "catalog-file",
+			Usage: "record stored blocks in this local catalog file (bbolt)",
+		},
+		&cli.StringFlag{
+			Name:  "catalog-kv-bucket",
+			Usage: "record stored blocks in this JetStream key/value bucket",
+		},
 		&cli.BoolFlag{
 			Name:  "log-json",
 			Usage: "set log format to JSON",
@@ -110,10 +209,58 @@ func NewAppSkeleton[P Payload, K DestKey](setup func(c *cli.Context, options *Op
 		options.NATSStreamName = c.String("stream-name")
 		options.NATSConsumerName = c.String("consumer-name")
 		options.MaxAge = c.Duration("max-age")
+		options.FlushGrace = c.Duration("flush-grace")
+		options.FetchBatch = c.Int("fetch-batch")
+		options.FetchMaxBytes = c.Int("fetch-max-bytes")
+		options.FetchTimeout = c.Duration("fetch-timeout")
+		options.AdaptiveFetch = c.Bool("adaptive-fetch")
+		options.ConsumerRefresh = c.Duration("consumer-refresh")
+		options.IdleFlush = c.Bool("idle-flush")
+		options.ExactlyOnce = c.Bool("exactly-once")
+		options.LatePolicy = LatePolicy(c.String("late-policy"))
+		options.TimeFallback = TimeFallback(c.String("time-fallback"))
+		options.MaxPastTime = c.Duration("max-past-time")
+		options.MaxFutureTime = c.Duration("max-future-time")
 		options.BufferToDisk = c.Bool("buffer-to-disk")
+		options.MaxMemoryBytes = c.Int64("max-memory-bytes")
+		options.MemoryPolicy = MemoryPolicy(c.String("memory-policy"))
+		options.MaxSpoolBytes = c.Int64("max-spool-bytes")
+		options.MinFreeSpoolBytes = c.Uint64("min-free-spool-bytes")
 		options.Compression = Compression(c.String("compression"))
 		options.TempDir = c.Path("tmp-dir")
 
+		if c.IsSet("window") {
+			loc, err := time.LoadLocation(c.String("window-location"))
+			if err != nil {
+				return err
+			}
+
+			if options.Window, err = ParseWindow(c.String("window"), loc); err != nil {
+				return err
+			}
+		}
+
+		if c.IsSet("time-header") {
+			options.TimeExtractor = HeaderTime[P](c.String("time-header"), c.String("time-layout"))
+		}
+
+		if c.IsSet("encryption-key-file") {
+			if options.Encryption, err = NewLocalKeyWrapperFromFile(c.Path("encryption-key-file")); err != nil {
+				return err
+			}
+		}
+
+		if c.IsSet("catalog-file") {
+			catalog, err := OpenBoltCatalog(c.Path("catalog-file"))
+			if err != nil {
+				return err
+			}
+
+			defer catalog.Close()
+
+			options.Catalog = catalog
+		}
+
 		if setup != nil {
 			if err := setup(c, options); err != nil {
 				return err
@@ -158,6 +305,17 @@ func NewAppSkeleton[P Payload, K DestKey](setup func(c *cli.Context, options *Op
 
 		defer nc.Close()
 
+		if c.IsSet("catalog-kv-bucket") {
+			js, err := nc.JetStream()
+			if err != nil {
+				return err
+			}
+
+			if options.Catalog, err = NewKVCatalog(js, c.String("catalog-kv-bucket")); err != nil {
+				return err
+			}
+		}
+
 		return options.Build().Run(c.Context, nc)
 	}
 
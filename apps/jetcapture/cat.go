@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Intelecy/jet-capture"
+	"github.com/urfave/cli/v2"
+)
+
+var catCommand = &cli.Command{
+	Name:      "cat",
+	Usage:     "print the messages of captured NATS message blocks",
+	ArgsUsage: "PATH [PATH...]",
+	Description: "Reads blocks written by the ndjson app (i.e. NatsMessage records as new-line delimited JSON). A PATH " +
+		"can be a single block, or a capture tree which is read in partition order. Compression is detected from " +
+		"the file name.",
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "subject",
+			Usage: "only print messages matching this subject (wildcards are supported)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "header",
+			Usage: "only print messages with this header, e.g. 'Region' or 'Region=eu'",
+		},
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "only print messages from this time on",
+		},
+		&cli.StringFlag{
+			Name:  "to",
+			Usage: "only print messages before this time",
+		},
+		&cli.Uint64Flag{
+			Name:  "min-seq",
+			Usage: "only print messages with at least this stream sequence",
+		},
+		&cli.Uint64Flag{
+			Name:  "max-seq",
+			Usage: "only print messages with at most this stream sequence",
+		},
+		&cli.StringFlag{
+			Name:  "data",
+			Value: "text",
+			Usage: `decode message data as "text", "json" or keep it as "base64"`,
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Value:   "table",
+			Usage:   `choose from "table", "json" (one object per line) or "raw" (just the data)`,
+		},
+		&cli.PathFlag{
+			Name:  "encryption-key-file",
+			Usage: "key for encrypted blocks (32 raw bytes, hex or base64)",
+		},
+		locationFlag(),
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() == 0 {
+			return fmt.Errorf("at least one PATH is required")
+		}
+
+		loc, err := time.LoadLocation(c.String(locationFlagName))
+		if err != nil {
+			return err
+		}
+
+		query := jetcapture.MessageQuery{
+			Subjects: c.StringSlice("subject"),
+			Headers:  map[string]string{},
+			MinSeq:   c.Uint64("min-seq"),
+			MaxSeq:   c.Uint64("max-seq"),
+		}
+
+		for _, h := range c.StringSlice("header") {
+			name, value, _ := strings.Cut(h, "=")
+			query.Headers[name] = value
+		}
+
+		if c.IsSet("from") {
+			if query.From, err = parseTime(c.String("from"), loc); err != nil {
+				return err
+			}
+		}
+
+		if c.IsSet("to") {
+			if query.To, err = parseTime(c.String("to"), loc); err != nil {
+				return err
+			}
+		}
+
+		var kw jetcapture.KeyWrapper
+
+		if c.IsSet("encryption-key-file") {
+			if kw, err = jetcapture.NewLocalKeyWrapperFromFile(c.Path("encryption-key-file")); err != nil {
+				return err
+			}
+		}
+
+		decode, err := dataDecoder(c.String("data"))
+		if err != nil {
+			return err
+		}
+
+		out := bufio.NewWriter(c.App.Writer)
+		defer out.Flush()
+
+		printer, err := newMessagePrinter(c.String("output"), out, decode)
+		if err != nil {
+			return err
+		}
+
+		match := query.Matcher()
+
+		for _, p := range c.Args().Slice() {
+			files, err := blockFiles(p, loc)
+			if err != nil {
+				return err
+			}
+
+			for _, f := range files {
+				if err := catBlock(f, kw, func(m *jetcapture.NatsMessage) error {
+					if !match(m) {
+						return nil
+					}
+					return printer.print(m)
+				}); err != nil {
+					return fmt.Errorf("%s: %w", f, err)
+				}
+			}
+		}
+
+		if err := printer.flush(); err != nil {
+			return err
+		}
+
+		return out.Flush()
+	},
+}
+
+// blockFiles returns the block itself, or the blocks of a tree
+func blockFiles(p string, loc *time.Location) ([]string, error) {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.IsDir() {
+		return []string{p}, nil
+	}
+
+	blocks, err := jetcapture.ListBlocks(p, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	// read the whole tree in time order rather than grouped by destination directory
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].Partition.Before(blocks[j].Partition)
+	})
+
+	// every block is read, since partitions don't bound the stream time of their messages: late messages (see
+	// LatePolicy) are written to later partitions, and with a TimeExtractor blocks are partitioned by event time
+	files := make([]string, 0, len(blocks))
+
+	for _, b := range blocks {
+		files = append(files, b.Path)
+	}
+
+	return files, nil
+}
+
+func catBlock(p string, kw jetcapture.KeyWrapper, fn func(m *jetcapture.NatsMessage) error) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	r, err := jetcapture.OpenBlock(bufio.NewReader(f), filepath.Base(p), kw)
+	if err != nil {
+		return err
+	}
+
+	return jetcapture.ReadNatsMessages(r, fn)
+}
+
+// dataDecoder returns a function that renders message data for JSON output
+func dataDecoder(mode string) (func(data []byte) any, error) {
+	switch mode {
+	case "text":
+		return func(data []byte) any { return string(data) }, nil
+	case "json":
+		return func(data []byte) any {
+			if json.Valid(data) {
+				return json.RawMessage(data)
+			}
+			// fall back to text so a single odd message doesn't break the output
+			return string(data)
+		}, nil
+	case "base64":
+		return func(data []byte) any { return base64.StdEncoding.EncodeToString(data) }, nil
+	default:
+		return nil, fmt.Errorf(`invalid data mode %q, choose from "text", "json" or "base64"`, mode)
+	}
+}
+
+type messagePrinter struct {
+	print func(m *jetcapture.NatsMessage) error
+	flush func() error
+}
+
+func newMessagePrinter(mode string, out io.Writer, decode func([]byte) any) (*messagePrinter, error) {
+	nop := func() error { return nil }
+
+	switch mode {
+	case "table":
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "TIME\tSTREAM\tSEQ\tSUBJECT\tHEADERS\tDATA")
+
+		return &messagePrinter{
+			print: func(m *jetcapture.NatsMessage) error {
+				var (
+					ts, stream, seq string
+					headers         []string
+				)
+
+				if md := m.Metadata; md != nil {
+					ts = md.Timestamp.Format(time.RFC3339Nano)
+					stream = md.Stream
+					seq = strconv.FormatUint(md.Sequence.Stream, 10)
+				}
+
+				for k, values := range m.Header {
+					for _, v := range values {
+						headers = append(headers, k+"="+v)
+					}
+				}
+
+				sort.Strings(headers)
+
+				data, err := json.Marshal(decode(m.Data))
+				if err != nil {
+					return err
+				}
+
+				_, err = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+					ts, stream, seq, m.Subject, strings.Join(headers, ","), data)
+				return err
+			},
+			flush: tw.Flush,
+		}, nil
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetEscapeHTML(false)
+
+		return &messagePrinter{
+			print: func(m *jetcapture.NatsMessage) error {
+				return enc.Encode(struct {
+					*jetcapture.NatsMessage
+					Data any `json:"data"`
+				}{m, decode(m.Data)})
+			},
+			flush: nop,
+		}, nil
+	case "raw":
+		return &messagePrinter{
+			print: func(m *jetcapture.NatsMessage) error {
+				if _, err := out.Write(m.Data); err != nil {
+					return err
+				}
+				_, err := io.WriteString(out, "\n")
+				return err
+			},
+			flush: nop,
+		}, nil
+	default:
+		return nil, fmt.Errorf(`invalid output %q, choose from "table", "json" or "raw"`, mode)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Intelecy/jet-capture"
+	"github.com/urfave/cli/v2"
+)
+
+var catalogCommand = &cli.Command{
+	Name:  "catalog",
+	Usage: "list the stored blocks covering a time window or sequence range",
+	Description: "Queries a block catalog written by a capture with --catalog-file or --catalog-kv-bucket and prints " +
+		"the blocks that overlap the time window and/or stream sequence range.",
+	Flags: append(append([]cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "dest",
+			Usage: "only list blocks of this destination key",
+		},
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "start of the time window",
+		},
+		&cli.StringFlag{
+			Name:  "to",
+			Usage: "end of the time window",
+		},
+		&cli.Uint64Flag{
+			Name:  "min-seq",
+			Usage: "start of the stream sequence range",
+		},
+		&cli.Uint64Flag{
+			Name:  "max-seq",
+			Usage: "end of the stream sequence range",
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Value:   "paths",
+			Usage:   `choose from "paths", "table" or "json" (one entry per line)`,
+		},
+		&cli.StringFlag{
+			Name:  locationFlagName,
+			Value: "Local",
+			Usage: "time zone for --from and --to values without a zone",
+		},
+	}, catalogFlags()...), natsFlags()...),
+	Action: func(c *cli.Context) error {
+		loc, err := time.LoadLocation(c.String(locationFlagName))
+		if err != nil {
+			return err
+		}
+
+		query := jetcapture.CatalogQuery{
+			DestKeys: c.StringSlice("dest"),
+			MinSeq:   c.Uint64("min-seq"),
+			MaxSeq:   c.Uint64("max-seq"),
+		}
+
+		if c.IsSet("from") {
+			if query.From, err = parseTime(c.String("from"), loc); err != nil {
+				return err
+			}
+		}
+
+		if c.IsSet("to") {
+			if query.To, err = parseTime(c.String("to"), loc); err != nil {
+				return err
+			}
+		}
+
+		catalog, closeCatalog, err := openCatalog(c)
+		if err != nil {
+			return err
+		}
+
+		defer closeCatalog()
+
+		if catalog == nil {
+			return fmt.Errorf("either --catalog-file or --catalog-kv-bucket is required")
+		}
+
+		entries, err := catalog.Query(c.Context, query)
+		if err != nil {
+			return err
+		}
+
+		switch c.String("output") {
+		case "paths":
+			for _, e := range entries {
+				fmt.Fprintln(c.App.Writer, e.Path)
+			}
+		case "table":
+			tw := tabwriter.NewWriter(c.App.Writer, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "DEST\tSTART\tEND\tFIRST SEQ\tLAST SEQ\tROWS\tSIZE\tPATH")
+			for _, e := range entries {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\n",
+					e.DestKey, e.Start.In(loc).Format(time.RFC3339), e.End.In(loc).Format(time.RFC3339),
+					e.FirstSeq, e.LastSeq, e.RowCount, e.Size, e.Path)
+			}
+			return tw.Flush()
+		case "json":
+			enc := json.NewEncoder(c.App.Writer)
+			for _, e := range entries {
+				if err := enc.Encode(e); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf(`invalid output %q, choose from "paths", "table" or "json"`, c.String("output"))
+		}
+
+		return nil
+	},
+}
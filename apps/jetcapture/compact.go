@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/Intelecy/jet-capture"
+	"github.com/urfave/cli/v2"
+)
+
+var compactCommand = &cli.Command{
+	Name:      "compact",
+	Usage:     "merge the blocks of a local capture tree into daily or hourly blocks",
+	ArgsUsage: "ROOT",
+	Description: "Merges the NDJSON or CSV blocks of each destination directory in ROOT into one block per --period. " +
+		"Merged blocks are written to --output (defaults to ROOT), and the source blocks are only removed once the " +
+		"merged block was written and its row count verified. With --catalog-file or --catalog-kv-bucket, the catalog " +
+		"entries of the source blocks are replaced with an entry of the merged block.",
+	Flags: append(append([]cli.Flag{
+		&cli.DurationFlag{
+			Name:  "period",
+			Value: jetcapture.DefaultCompactPeriod,
+			Usage: "window merged into a single block, must evenly divide a day (e.g. 1h or 24h)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "dest",
+			Usage: "only compact this destination directory (relative to ROOT)",
+		},
+		&cli.StringFlag{
+			Name:  "from",
+			Usage: "only compact blocks from this time on",
+		},
+		&cli.StringFlag{
+			Name:  "to",
+			Usage: "only compact blocks before this time",
+		},
+		&cli.PathFlag{
+			Name:  "output",
+			Usage: "root directory for the merged blocks (defaults to ROOT)",
+		},
+		&cli.BoolFlag{
+			Name:  "csv-no-header",
+			Usage: "CSV blocks don't start with a header row",
+		},
+		&cli.PathFlag{
+			Name:  "encryption-key-file",
+			Usage: "key for encrypted blocks (32 raw bytes, hex or base64)",
+		},
+		&cli.PathFlag{
+			Name:  "tmp-dir",
+			Usage: "directory used to buffer merged blocks",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only print what would be merged",
+		},
+		locationFlag(),
+	}, catalogFlags()...), natsFlags()...),
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return fmt.Errorf("exactly one ROOT is required")
+		}
+
+		root := c.Args().First()
+
+		loc, err := time.LoadLocation(c.String(locationFlagName))
+		if err != nil {
+			return err
+		}
+
+		opts := jetcapture.CompactOptions{
+			Period:      c.Duration("period"),
+			CSVNoHeader: c.Bool("csv-no-header"),
+			TempDir:     c.Path("tmp-dir"),
+			DryRun:      c.Bool("dry-run"),
+			Location:    loc,
+		}
+
+		var closeCatalog func()
+		if opts.Catalog, closeCatalog, err = openCatalog(c); err != nil {
+			return err
+		}
+
+		defer closeCatalog()
+
+		for _, d := range c.StringSlice("dest") {
+			opts.DestDirs = append(opts.DestDirs, filepath.Clean(d))
+		}
+
+		if c.IsSet("from") {
+			if opts.From, err = parseTime(c.String("from"), loc); err != nil {
+				return err
+			}
+		}
+
+		if c.IsSet("to") {
+			if opts.To, err = parseTime(c.String("to"), loc); err != nil {
+				return err
+			}
+		}
+
+		if c.IsSet("encryption-key-file") {
+			if opts.KeyWrapper, err = jetcapture.NewLocalKeyWrapperFromFile(c.Path("encryption-key-file")); err != nil {
+				return err
+			}
+		}
+
+		output := root
+		if c.IsSet("output") {
+			output = c.Path("output")
+		}
+
+		store := &jetcapture.LocalFSStore[string]{
+			Resolver: func(destDir string) (string, error) {
+				return filepath.Join(output, destDir), nil
+			},
+		}
+
+		results, err := jetcapture.Compact[string](c.Context, root, store, func(destDir string) (string, error) {
+			return destDir, nil
+		}, opts)
+
+		for _, r := range results {
+			if opts.DryRun {
+				fmt.Fprintf(c.App.Writer, "%s: would merge %d blocks (%d bytes)\n",
+					r.Start.Format(time.RFC3339), len(r.Sources), r.Bytes)
+				for _, b := range r.Sources {
+					fmt.Fprintf(c.App.Writer, "  %s\n", b.Path)
+				}
+				continue
+			}
+
+			fmt.Fprintf(c.App.Writer, "%s: merged %d blocks (%d rows, %d bytes)\n", r.Path, len(r.Sources), r.Rows, r.Bytes)
+		}
+
+		return err
+	},
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Intelecy/jet-capture"
+	"github.com/nats-io/jsm.go/natscontext"
+	"github.com/nats-io/nats.go"
+	"github.com/urfave/cli/v2"
+)
+
+const locationFlagName = "location"
+
+func locationFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  locationFlagName,
+		Value: "Local",
+		Usage: "time zone the partition directories were written in, e.g. 'UTC'",
+	}
+}
+
+// parseTime accepts RFC 3339 timestamps, or dates and times without a zone which are interpreted in loc
+func parseTime(v string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 or YYYY-MM-DD[THH:MM[:SS]]", v)
+}
+
+func natsFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "nats-context",
+			EnvVars: []string{"NATS_CONTEXT"},
+			Usage:   "NATS context name",
+		},
+		&cli.StringFlag{
+			Name:    "nats-server",
+			EnvVars: []string{"NATS_URL"},
+			Value:   nats.DefaultURL,
+		},
+		&cli.PathFlag{
+			Name:    "nats-creds",
+			EnvVars: []string{"NATS_CREDS"},
+			Usage:   "NATS user credentials",
+		},
+	}
+}
+
+func connectNATS(c *cli.Context) (*nats.Conn, error) {
+	var options []nats.Option
+
+	if c.IsSet("nats-context") {
+		nctx, err := natscontext.New(c.String("nats-context"), true)
+		if err != nil {
+			return nil, err
+		}
+
+		if options, err = nctx.NATSOptions(); err != nil {
+			return nil, err
+		}
+	} else if c.IsSet("nats-creds") {
+		options = append(options, nats.UserCredentials(c.Path("nats-creds")))
+	}
+
+	return nats.Connect(c.String("nats-server"), options...)
+}
+
+func catalogFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.PathFlag{
+			Name:  "catalog-file",
+			Usage: "local catalog file (bbolt)",
+		},
+		&cli.StringFlag{
+			Name:  "catalog-kv-bucket",
+			Usage: "JetStream key/value bucket of the catalog",
+		},
+	}
+}
+
+// openCatalog opens the catalog selected by the catalogFlags, or returns nil if neither flag is set. close releases
+// the catalog file or NATS connection.
+func openCatalog(c *cli.Context) (jetcapture.Catalog, func(), error) {
+	switch {
+	case c.IsSet("catalog-file"):
+		bc, err := jetcapture.OpenBoltCatalog(c.Path("catalog-file"))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return bc, func() { _ = bc.Close() }, nil
+	case c.IsSet("catalog-kv-bucket"):
+		nc, err := connectNATS(c)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var kc *jetcapture.KVCatalog
+
+		js, err := nc.JetStream()
+		if err == nil {
+			kc, err = jetcapture.NewKVCatalog(js, c.String("catalog-kv-bucket"))
+		}
+
+		if err != nil {
+			nc.Close()
+			return nil, nil, err
+		}
+
+		return kc, nc.Close, nil
+	default:
+		return nil, func() {}, nil
+	}
+}
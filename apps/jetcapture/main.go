@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+)
+
+// jetcapture is a companion tool for working with the output of a capture (e.g. the ndjson app)
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	app := cli.NewApp()
+
+	app.Name = "jetcapture"
+	app.Usage = "maintain and inspect captured block trees"
+	app.Suggest = true
+	app.Authors = []*cli.Author{{
+		Name:  "Jonathan Camp",
+		Email: "jonathan.camp@intelecy.com",
+	}}
+	app.Copyright = "2022 Intelecy AS"
+
+	app.Commands = []*cli.Command{
+		pruneCommand,
+		compactCommand,
+		catCommand,
+		catalogCommand,
+	}
+
+	if err := app.RunContext(ctx, os.Args); err != nil {
+		cancel()
+		log.Fatal(err)
+	}
+}
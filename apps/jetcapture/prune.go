@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Intelecy/jet-capture"
+	"github.com/urfave/cli/v2"
+)
+
+var pruneCommand = &cli.Command{
+	Name:      "prune",
+	Usage:     "remove old blocks from a local capture tree",
+	ArgsUsage: "ROOT [ROOT...]",
+	Description: "Walks each ROOT for blocks in YYYY/MM/DD/HH/mm partition directories and removes the blocks that are " +
+		"older than the retention of their destination directory, or the oldest blocks while free space is below " +
+		"--min-free-bytes. Empty partition directories are removed afterwards, and so are the entries of removed blocks " +
+		"in the catalog given by --catalog-file or --catalog-kv-bucket.",
+	Flags: append(append([]cli.Flag{
+		&cli.DurationFlag{
+			Name:  "retention",
+			Usage: "remove blocks older than this (0 disables)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "retention-for",
+			Usage: "retention for a single destination directory (relative to ROOT), e.g. 'orders/eu=720h'",
+		},
+		&cli.IntFlag{
+			Name:  "keep-last",
+			Usage: "always keep the newest N blocks of each destination directory",
+		},
+		&cli.Uint64Flag{
+			Name:  "min-free-bytes",
+			Usage: "remove the oldest blocks until at least this many bytes are free (0 disables)",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only print what would be removed",
+		},
+		locationFlag(),
+	}, catalogFlags()...), natsFlags()...),
+	Action: func(c *cli.Context) error {
+		if c.NArg() == 0 {
+			return fmt.Errorf("at least one ROOT is required")
+		}
+
+		loc, err := time.LoadLocation(c.String(locationFlagName))
+		if err != nil {
+			return err
+		}
+
+		opts := jetcapture.PruneOptions{
+			Retention:    c.Duration("retention"),
+			Retentions:   map[string]time.Duration{},
+			KeepLast:     c.Int("keep-last"),
+			MinFreeBytes: c.Uint64("min-free-bytes"),
+			DryRun:       c.Bool("dry-run"),
+			Location:     loc,
+		}
+
+		var closeCatalog func()
+		if opts.Catalog, closeCatalog, err = openCatalog(c); err != nil {
+			return err
+		}
+
+		defer closeCatalog()
+
+		for _, v := range c.StringSlice("retention-for") {
+			dir, d, ok := strings.Cut(v, "=")
+			if !ok {
+				return fmt.Errorf("invalid --retention-for %q, expected DIR=DURATION", v)
+			}
+
+			if opts.Retentions[filepath.Clean(dir)], err = time.ParseDuration(d); err != nil {
+				return fmt.Errorf("invalid --retention-for %q: %w", v, err)
+			}
+		}
+
+		for _, root := range c.Args().Slice() {
+			result, err := jetcapture.Prune(root, opts)
+
+			for _, b := range result.Removed {
+				fmt.Fprintln(c.App.Writer, b.Path)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			verb := "removed"
+			if opts.DryRun {
+				verb = "would remove"
+			}
+
+			fmt.Fprintf(c.App.ErrWriter, "%s: %s %d blocks (%d bytes) and %d directories\n",
+				root, verb, len(result.Removed), result.Bytes, len(result.RemovedDirs))
+		}
+
+		return nil
+	},
+}
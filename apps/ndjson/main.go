@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -9,6 +10,7 @@ import (
 	"syscall"
 
 	"github.com/Intelecy/jet-capture"
+	"github.com/nats-io/nats.go"
 	"github.com/urfave/cli/v2"
 )
 
@@ -31,10 +33,21 @@ func main() {
 		Usage:    "local output directory",
 	}
 
-	groupByFlag := &cli.BoolFlag{
+	groupBySubjectFlag := &cli.BoolFlag{
 		Name:  "group-by-subject",
 		Value: false,
-		Usage: "should the output files be grouped by message subject",
+		Usage: "should the output files be grouped by message subject (same as --group-by '{{subject}}')",
+	}
+
+	groupByFlag := &cli.StringFlag{
+		Name:  "group-by",
+		Usage: "key expression used to group the output files, e.g. '{{token(1)}}/{{header(Region, unknown)}}'",
+	}
+
+	groupByDefaultFlag := &cli.StringFlag{
+		Name:  "group-by-default",
+		Value: "_unknown",
+		Usage: "group used for messages where the --group-by expression can't be resolved",
 	}
 
 	// initialize the cli app and implement the required callback to finish setting
@@ -43,19 +56,53 @@ func main() {
 	app := jetcapture.NewAppSkeleton[P, K](func(c *cli.Context, options *jetcapture.Options[P, K]) error {
 		options.Suffix = "json"
 
+		groupBy := c.String(groupByFlag.Name)
+
+		if groupBy == "" && c.Bool(groupBySubjectFlag.Name) {
+			groupBy = "{{subject}}"
+		}
+
 		// set up a decoder that just copies the underlying NATS message to a struct that can easily be serialized
-		// and also set the destination key to the nats subject
-		options.MessageDecoder = jetcapture.NatsToNats[K](jetcapture.SubjectToDestKey)
+		// and also set the destination key using the group by expression (if any)
+		if groupBy != "" {
+			resolver, err := jetcapture.KeyExpression(groupBy)
+			if err != nil {
+				return fmt.Errorf("invalid --%s expression: %w", groupByFlag.Name, err)
+			}
+
+			def := c.String(groupByDefaultFlag.Name)
+			if !filepath.IsLocal(def) {
+				return fmt.Errorf("--%s %q is not a valid relative path", groupByDefaultFlag.Name, def)
+			}
+
+			// keys may come from headers, so groups that would escape the output directory use the default instead.
+			// failing them in the store would only get them redelivered forever.
+			local := func(msg *nats.Msg) (string, error) {
+				group, err := resolver(msg)
+				if err == nil && !filepath.IsLocal(group) {
+					return "", fmt.Errorf("group %q is not a valid relative path", group)
+				}
+				return group, err
+			}
+
+			options.MessageDecoder = jetcapture.NatsToNats[K](jetcapture.KeyResolver[K](local).OrDefault(def))
+		} else {
+			options.MessageDecoder = jetcapture.NatsToNats[K](jetcapture.SubjectToDestKey)
+		}
 
 		// set up a new-line delimited JSON writer
 		options.WriterFactory = func() jetcapture.FormattedDataWriter[P] {
 			return &jetcapture.NewLineDelimitedJSON[P]{}
 		}
 
-		if c.Bool(groupByFlag.Name) {
+		if groupBy != "" {
 			options.Store = &jetcapture.LocalFSStore[K]{
-				Resolver: func(subject K) (string, error) {
-					return filepath.Join(c.Path(outputFlag.Name), subject), nil
+				Resolver: func(group K) (string, error) {
+					// the decoder already maps invalid groups to the default, this is just a safety net
+					if !filepath.IsLocal(group) {
+						return "", fmt.Errorf("group %q is not a valid relative path", group)
+					}
+					return filepath.Join(c.Path(outputFlag.Name), group), nil
 				},
 			}
 		} else {
@@ -75,7 +122,7 @@ func main() {
 	app.Copyright = "2022 Intelecy AS"
 
 	// append our additional flags
-	app.Flags = append(app.Flags, outputFlag, groupByFlag)
+	app.Flags = append(app.Flags, outputFlag, groupBySubjectFlag, groupByFlag, groupByDefaultFlag)
 
 	// and liftoff!
 	if err := app.RunContext(ctx, os.Args); err != nil {
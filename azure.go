@@ -2,13 +2,18 @@ package jetcapture
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"net"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 )
 
 const azureUploadBufferSz = 64 * 1024 * 1024
@@ -19,15 +24,20 @@ var (
 
 // BuildURLBase should return a URL that serves as the base for the block
 // For example: https://capture.blob.core.windows.net/backup/from-stream-foo/
+// Path-style URLs used by Azurite are supported as well, e.g. http://127.0.0.1:10000/devstoreaccount1/backup/foo/
 type BuildURLBase[K DestKey] func(ctx context.Context, destKey K) (string, error)
 
 // OverrideUploadOptions is an optional function to override various upload option (e.g. AccessTier)
 type OverrideUploadOptions[K DestKey] func(options *azblob.UploadStreamOptions, destKey K)
 
 type AzureBlobStore[K DestKey] struct {
-	credz          azcore.TokenCredential
+	newClient      func(serviceURL string) (*azblob.Client, error)
 	buildURLBaseFn BuildURLBase[K]
 	optionsFn      OverrideUploadOptions[K]
+
+	// clients are cached per service URL (i.e. storage account)
+	clients   map[string]*azblob.Client
+	clientsMu sync.Mutex
 }
 
 func NewAzureBlobStore[K DestKey](
@@ -36,11 +46,123 @@ func NewAzureBlobStore[K DestKey](
 	optionsFn OverrideUploadOptions[K],
 
 ) (*AzureBlobStore[K], error) {
+	return newAzureBlobStore(func(serviceURL string) (*azblob.Client, error) {
+		return azblob.NewClient(serviceURL, credential, nil)
+	}, buildURLBaseFn, optionsFn), nil
+}
+
+// NewAzureBlobStoreWithSharedKey authenticates using a storage account name and key
+func NewAzureBlobStoreWithSharedKey[K DestKey](
+	accountName, accountKey string,
+	buildURLBaseFn BuildURLBase[K],
+	optionsFn OverrideUploadOptions[K],
+) (*AzureBlobStore[K], error) {
+	credential, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAzureBlobStore(func(serviceURL string) (*azblob.Client, error) {
+		return azblob.NewClientWithSharedKeyCredential(serviceURL, credential, nil)
+	}, buildURLBaseFn, optionsFn), nil
+}
+
+// NewAzureBlobStoreWithSAS authenticates using a shared access signature token (the query string of a SAS URL)
+func NewAzureBlobStoreWithSAS[K DestKey](
+	sasToken string,
+	buildURLBaseFn BuildURLBase[K],
+	optionsFn OverrideUploadOptions[K],
+) (*AzureBlobStore[K], error) {
+	sasToken = strings.TrimPrefix(sasToken, "?")
+
+	if _, err := url.ParseQuery(sasToken); err != nil {
+		return nil, fmt.Errorf("invalid SAS token: %w", err)
+	}
+
+	return newAzureBlobStore(func(serviceURL string) (*azblob.Client, error) {
+		return azblob.NewClientWithNoCredential(serviceURL+"?"+sasToken, nil)
+	}, buildURLBaseFn, optionsFn), nil
+}
+
+// NewAzureBlobStoreFromConnectionString uses a storage account connection string (e.g.
+// `UseDevelopmentStorage=true` for Azurite). The storage account is taken from the connection string, so only the
+// container and blob prefix of the URL returned by buildURLBaseFn are used.
+func NewAzureBlobStoreFromConnectionString[K DestKey](
+	connectionString string,
+	buildURLBaseFn BuildURLBase[K],
+	optionsFn OverrideUploadOptions[K],
+) (*AzureBlobStore[K], error) {
+	client, err := azblob.NewClientFromConnectionString(connectionString, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAzureBlobStore(func(string) (*azblob.Client, error) {
+		return client, nil
+	}, buildURLBaseFn, optionsFn), nil
+}
+
+func newAzureBlobStore[K DestKey](
+	newClient func(serviceURL string) (*azblob.Client, error),
+	buildURLBaseFn BuildURLBase[K],
+	optionsFn OverrideUploadOptions[K],
+) *AzureBlobStore[K] {
 	return &AzureBlobStore[K]{
+		newClient:      newClient,
 		buildURLBaseFn: buildURLBaseFn,
 		optionsFn:      optionsFn,
-		credz:          credential,
-	}, nil
+		clients:        map[string]*azblob.Client{},
+	}
+}
+
+func (a *AzureBlobStore[K]) client(serviceURL string) (*azblob.Client, error) {
+	a.clientsMu.Lock()
+	defer a.clientsMu.Unlock()
+
+	if bc, ok := a.clients[serviceURL]; ok {
+		return bc, nil
+	}
+
+	bc, err := a.newClient(serviceURL)
+	if err != nil {
+		return nil, err
+	}
+
+	a.clients[serviceURL] = bc
+
+	return bc, nil
+}
+
+// parseAzureURLBase splits a base URL into the service (account) URL, the container and the blob prefix
+func parseAzureURLBase(base string) (serviceURL, container, prefix string, err error) {
+	parsed, err := url.Parse(base)
+	if err != nil {
+		return _EMPTY_, _EMPTY_, _EMPTY_, fmt.Errorf("invalid URL base %q: %w", base, err)
+	}
+
+	scheme := parsed.Scheme
+	if scheme == _EMPTY_ {
+		scheme = "https"
+	}
+
+	serviceURL = scheme + "://" + parsed.Host
+
+	p := strings.TrimPrefix(parsed.Path, "/")
+
+	// emulators (e.g. Azurite) use path-style URLs with the account name as the first path segment
+	if host := parsed.Hostname(); host == "localhost" || net.ParseIP(host) != nil {
+		var account string
+		account, p, _ = strings.Cut(p, "/")
+		serviceURL += "/" + account
+	}
+
+	container, prefix, _ = strings.Cut(p, "/")
+
+	if container == _EMPTY_ {
+		return _EMPTY_, _EMPTY_, _EMPTY_, fmt.Errorf("URL base %q has no container", base)
+	}
+
+	return serviceURL, container, prefix, nil
 }
 
 func (a *AzureBlobStore[K]) Write(ctx context.Context, block io.Reader, destKey K, dir, fileName string) (string, int64, time.Duration, error) {
@@ -51,23 +173,36 @@ func (a *AzureBlobStore[K]) Write(ctx context.Context, block io.Reader, destKey
 		return "", 0, 0, err
 	}
 
-	parsed, _ := url.Parse(base)
-	container, blobName, _ := strings.Cut(parsed.Path, "/")
+	serviceURL, container, blobName, err := parseAzureURLBase(base)
+	if err != nil {
+		return "", 0, 0, err
+	}
 
 	u, err := url.JoinPath(blobName, dir, fileName)
 	if err != nil {
 		return "", 0, 0, err
 	}
 
-	log.Infof("writing block to https://%s%s/%s", parsed.Host, container, u)
+	log.Infof("writing block to %s/%s/%s", serviceURL, container, u)
 
-	bc, err := azblob.NewClient("https://"+parsed.Host, a.credz, nil)
+	bc, err := a.client(serviceURL)
 	if err != nil {
 		return "", 0, 0, err
 	}
 
 	var options azblob.UploadStreamOptions
 
+	if m, ok := ManifestOf(block); ok {
+		options.Metadata = map[string]*string{}
+		for k, v := range m.Metadata() {
+			options.Metadata[k] = to.Ptr(v)
+		}
+
+		if ce := m.ContentEncoding(); ce != _EMPTY_ {
+			options.HTTPHeaders = &blob.HTTPHeaders{BlobContentEncoding: to.Ptr(ce)}
+		}
+	}
+
 	if a.optionsFn != nil {
 		a.optionsFn(&options, destKey)
 	}
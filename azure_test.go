@@ -0,0 +1,165 @@
+package jetcapture
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAzureBlob struct {
+	data     []byte
+	metadata map[string]string
+	encoding string
+}
+
+// fakeAzure implements just enough of the blob REST API for `UploadStream` of small blocks
+type fakeAzure struct {
+	mu    sync.Mutex
+	blobs map[string]*fakeAzureBlob
+	auth  []string
+}
+
+func newFakeAzure(t *testing.T) (*fakeAzure, *httptest.Server) {
+	f := &fakeAzure{
+		blobs: map[string]*fakeAzureBlob{},
+	}
+
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	return f, srv
+}
+
+func (f *fakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.auth = append(f.auth, r.Header.Get("Authorization")+r.URL.Query().Get("sig"))
+
+	body, _ := io.ReadAll(r.Body)
+
+	switch q := r.URL.Query(); {
+	case r.Method == http.MethodPut && q.Get("comp") == "":
+		// uploads smaller than the block size are done in a single request
+		f.putBlob(r, body)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (f *fakeAzure) putBlob(r *http.Request, data []byte) {
+	b := &fakeAzureBlob{
+		data:     data,
+		metadata: map[string]string{},
+		encoding: r.Header.Get("X-Ms-Blob-Content-Encoding"),
+	}
+
+	for k, v := range r.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-ms-meta-") {
+			b.metadata[strings.TrimPrefix(k, "x-ms-meta-")] = v[0]
+		}
+	}
+
+	f.blobs[r.URL.Path] = b
+}
+
+func TestParseAzureURLBase(t *testing.T) {
+	assert := require.New(t)
+
+	for base, expected := range map[string][3]string{
+		"https://capture.blob.core.windows.net/backup/from-stream-foo/": {"https://capture.blob.core.windows.net", "backup", "from-stream-foo/"},
+		"https://capture.blob.core.windows.net/backup":                  {"https://capture.blob.core.windows.net", "backup", ""},
+		"http://127.0.0.1:10000/devstoreaccount1/backup/foo":            {"http://127.0.0.1:10000/devstoreaccount1", "backup", "foo"},
+		"//capture.blob.core.windows.net/backup/foo":                    {"https://capture.blob.core.windows.net", "backup", "foo"},
+	} {
+		serviceURL, container, prefix, err := parseAzureURLBase(base)
+		assert.Nil(err, base)
+		assert.Equal(expected, [3]string{serviceURL, container, prefix}, base)
+	}
+
+	for _, base := range []string{"https://capture.blob.core.windows.net/", "http://[::1", "http://localhost:10000/account"} {
+		_, _, _, err := parseAzureURLBase(base)
+		assert.NotNil(err, base)
+	}
+}
+
+func TestAzureBlobStore(t *testing.T) {
+	assert := require.New(t)
+
+	fake, srv := newFakeAzure(t)
+
+	build := func(_ context.Context, dk string) (string, error) {
+		return srv.URL + "/devstoreaccount1/backup/" + dk, nil
+	}
+
+	var clients int
+
+	shared, err := NewAzureBlobStoreWithSharedKey[string]("devstoreaccount1", base64.StdEncoding.EncodeToString([]byte("key")), build, nil)
+	assert.Nil(err)
+
+	newClient := shared.newClient
+	shared.newClient = func(serviceURL string) (*azblob.Client, error) {
+		clients++
+		return newClient(serviceURL)
+	}
+
+	sas, err := NewAzureBlobStoreWithSAS[string]("?sv=2020-08-04&sig=secret", build, nil)
+	assert.Nil(err)
+
+	block := &manifestReader{
+		ReadSeeker: bytes.NewReader([]byte("hello azure")),
+		manifest: BlockManifest{
+			ID:           "01ARZ3NDEKTSV4RRFFQ69G5FAV",
+			Start:        time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC),
+			MessageCount: 2,
+			RowCount:     3,
+			FirstSeq:     10,
+			LastSeq:      11,
+			Compression:  GZip,
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = block.Seek(0, io.SeekStart)
+		assert.Nil(err)
+
+		p, n, _, err := shared.Write(context.Background(), block, "k1", "2024/01/02/03/00", "backup-1.csv.gz")
+		assert.Nil(err)
+		assert.Equal("k1/2024/01/02/03/00/backup-1.csv.gz", p)
+		assert.EqualValues(11, n)
+	}
+
+	assert.Equal(1, clients)
+
+	b := fake.blobs["/devstoreaccount1/backup/k1/2024/01/02/03/00/backup-1.csv.gz"]
+	assert.NotNil(b)
+	assert.Equal("hello azure", string(b.data))
+	assert.Equal("gzip", b.encoding)
+	assert.Equal("3", b.metadata["row_count"])
+	assert.Equal("10", b.metadata["first_seq"])
+	assert.Equal("11", b.metadata["last_seq"])
+	assert.Equal("2024-01-02T03:00:00Z", b.metadata["block_start"])
+	assert.True(strings.HasPrefix(fake.auth[0], "SharedKey devstoreaccount1:"))
+
+	_, _, _, err = sas.Write(context.Background(), strings.NewReader("plain"), "k2", "dir", "file")
+	assert.Nil(err)
+
+	b = fake.blobs["/devstoreaccount1/backup/k2/dir/file"]
+	assert.NotNil(b)
+	assert.Equal("plain", string(b.data))
+	assert.Equal("", b.encoding)
+	assert.Equal("secret", fake.auth[len(fake.auth)-1])
+}
@@ -17,37 +17,54 @@ type dataBlock[P Payload] struct {
 	id            string
 	start         time.Time
 	messageCount  int
+	skippedCount  int
 	rowCount      int
 	closed        bool
 	writer        FormattedDataWriter[P]
-	buffer        buffer
+	buffer        buffer // nil until the first message is written, see open
 	acks          []string
+	oldestMessage time.Time // only of written messages, unlike newestMessage
 	newestMessage time.Time
+	end           time.Time
+	opened        time.Time // the newest stream timestamp when the block was created
+	firstSeq      uint64
+	lastSeq       uint64
+	compression   Compression
+	encrypted     bool
+	late          bool                // the block holds messages for intervals that already expired
+	rewrite       *storedBlock        // the stored block these late messages are merged into (see LateRewrite)
+	seqs          map[uint64]struct{} // stream sequences written to the block, only tracked for ExactlyOnce
+	base          buffer              // the bottom of the buffer chain, i.e. a memoryBuffer or diskBuffer
 }
 
 func newDataBlock[P Payload](
 	start time.Time,
 	writer FormattedDataWriter[P],
-	buffer buffer,
 ) *dataBlock[P] {
 	b := &dataBlock[P]{
 		start:  start,
 		writer: writer,
-		buffer: buffer,
 		acks:   []string{},
 	}
 
-	writer.InitNew(buffer)
-
-	b.id = ulid.MustNew(ulid.Timestamp(start), ulid.DefaultEntropy()).String()
+	b.id = newBlockID(start)
 
 	return b
 }
 
+func newBlockID(start time.Time) string {
+	return ulid.MustNew(ulid.Timestamp(start), ulid.DefaultEntropy()).String()
+}
+
 func (b *dataBlock[P]) path() string {
+	return partitionPath(b.start)
+}
+
+// partitionPath renders the `YYYY/MM/DD/HH/mm/` directory blocks starting at t are stored in
+func partitionPath(t time.Time) string {
 	return fmt.Sprintf(
 		"%4d/%02d/%02d/%02d/%02d/",
-		b.start.Year(), b.start.Month(), b.start.Day(), b.start.Hour(), b.start.Minute(),
+		t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(),
 	)
 }
 
@@ -60,12 +77,49 @@ func (b *dataBlock[P]) fileName(prefix, suffix string) string {
 	)
 }
 
+// memorySize returns the number of bytes the block keeps in memory
+func (b *dataBlock[P]) memorySize() int64 {
+	if m, ok := b.base.(*memoryBuffer); ok {
+		return m.size
+	}
+	return 0
+}
+
+// spoolSize returns the number of bytes the block keeps in a temp file
+func (b *dataBlock[P]) spoolSize() int64 {
+	switch base := b.base.(type) {
+	case *diskBuffer:
+		return base.size
+	case *memoryBuffer:
+		if base.disk != nil {
+			return base.disk.size
+		}
+	}
+	return 0
+}
+
+// open sets the buffer the block is written to. Blocks that only skip messages never get one.
+func (b *dataBlock[P]) open(buf, base buffer) {
+	b.buffer = buf
+	b.base = base
+	b.writer.InitNew(buf)
+}
+
 func (b *dataBlock[P]) close() error {
 	b.closed = true
+	if b.buffer == nil {
+		return nil
+	}
 	return b.buffer.DoneWriting()
 }
 
-func (b *dataBlock[P]) ackAll(nc *nats.Conn) (int, error) {
+// acker publishes the acks of a block, i.e. a `*nats.Conn`
+type acker interface {
+	Publish(subj string, data []byte) error
+	Flush() error
+}
+
+func (b *dataBlock[P]) ackAll(nc acker) (int, error) {
 	acked := 0
 	// TODO(jonathan): this acks in the _reverse_ order... does it matter?
 	for _, ack := range b.acks {
@@ -78,9 +132,18 @@ func (b *dataBlock[P]) ackAll(nc *nats.Conn) (int, error) {
 	return acked, nc.Flush()
 }
 
-func (b *dataBlock[P]) write(payload P, ack string, md *nats.MsgMetadata) error {
-	if md.Timestamp.After(b.newestMessage) {
-		b.newestMessage = md.Timestamp
+func (b *dataBlock[P]) write(payload P, ack string, ts time.Time, md *nats.MsgMetadata) error {
+	if b.messageCount == 0 || ts.Before(b.oldestMessage) {
+		b.oldestMessage = ts
+	}
+	if ts.After(b.newestMessage) {
+		b.newestMessage = ts
+	}
+	if seq := md.Sequence.Stream; b.messageCount == 0 || seq < b.firstSeq {
+		b.firstSeq = seq
+	}
+	if seq := md.Sequence.Stream; seq > b.lastSeq {
+		b.lastSeq = seq
 	}
 	b.messageCount += 1
 	rows, err := b.writer.Write(payload)
@@ -88,14 +151,57 @@ func (b *dataBlock[P]) write(payload P, ack string, md *nats.MsgMetadata) error
 		// nacks?
 	} else {
 		b.acks = append(b.acks, ack)
+		if b.seqs != nil {
+			b.seqs[md.Sequence.Stream] = struct{}{}
+		}
 	}
 	b.rowCount += rows
 	return err
 }
 
+// written reports whether the message with the stream sequence was already written to the block (i.e. redelivered)
+func (b *dataBlock[P]) written(seq uint64) bool {
+	_, ok := b.seqs[seq]
+	return ok
+}
+
+// skip records a message that isn't written to the block, but should be acked along with it
+func (b *dataBlock[P]) skip(ack string, ts time.Time) {
+	if ts.After(b.newestMessage) {
+		b.newestMessage = ts
+	}
+	b.skippedCount += 1
+	b.acks = append(b.acks, ack)
+}
+
 func (b *dataBlock[P]) Read(p []byte) (int, error) {
 	if !b.closed {
 		panic("invalid block state")
 	}
 	return b.buffer.Read(p)
 }
+
+func (b *dataBlock[P]) Manifest() BlockManifest {
+	return BlockManifest{
+		ID:            b.id,
+		Start:         b.start,
+		End:           b.end,
+		OldestMessage: b.oldestMessage,
+		NewestMessage: b.newestMessage,
+		MessageCount:  b.messageCount,
+		RowCount:      b.rowCount,
+		FirstSeq:      b.firstSeq,
+		LastSeq:       b.lastSeq,
+		Compression:   b.compression,
+		Encrypted:     b.encrypted,
+		Late:          b.late,
+	}
+}
+
+// Seek allows stores to re-read a closed block (e.g. to retry, or to write it to several stores)
+func (b *dataBlock[P]) Seek(offset int64, whence int) (int64, error) {
+	if !b.closed {
+		panic("invalid block state")
+	}
+	return b.buffer.Seek(offset, whence)
+}
@@ -0,0 +1,99 @@
+package jetcapture
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/snappy"
+)
+
+// BlockIDOf returns the block id (see `BlockManifest.ID`) of a block file name, i.e. `prefix-ID.suffix`
+func BlockIDOf(fileName string) string {
+	id := fileName
+
+	if i := strings.LastIndexByte(id, '-'); i >= 0 {
+		id = id[i+1:]
+	}
+
+	if i := strings.IndexByte(id, '.'); i >= 0 {
+		id = id[:i]
+	}
+
+	return id
+}
+
+// ParseBlockFileName reverses `Capture.fileSuffix`, returning the format suffix (i.e. `Options.Suffix`, e.g. "csv"),
+// the compression and whether the block is encrypted
+func ParseBlockFileName(fileName string) (suffix string, compression Compression, encrypted bool) {
+	name := fileName
+
+	if strings.HasSuffix(name, ".enc") {
+		name = strings.TrimSuffix(name, ".enc")
+		encrypted = true
+	}
+
+	compression = None
+
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		name = strings.TrimSuffix(name, ".gz")
+		compression = GZip
+	case strings.HasSuffix(name, ".snappy"):
+		name = strings.TrimSuffix(name, ".snappy")
+		compression = Snappy
+	}
+
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		suffix = name[i+1:]
+	}
+
+	return suffix, compression, encrypted
+}
+
+// newCompressingWriter returns a writer compressing into w. Close flushes it, but doesn't close w.
+func newCompressingWriter(w io.Writer, compression Compression) io.WriteCloser {
+	switch compression {
+	case Snappy:
+		return snappy.NewBufferedWriter(w)
+	case GZip:
+		return gzip.NewWriter(w)
+	case None:
+		return nopWriteCloser{w}
+	default:
+		panic("unhandled compression type")
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// OpenBlock returns a reader for the plain contents of a stored block, undoing the encryption and compression
+// indicated by its file name. The KeyWrapper is only required for encrypted blocks.
+func OpenBlock(r io.Reader, fileName string, kw KeyWrapper) (io.Reader, error) {
+	_, compression, encrypted := ParseBlockFileName(fileName)
+
+	if encrypted {
+		if kw == nil {
+			return nil, errors.New("block is encrypted but no key was provided")
+		}
+
+		var err error
+		if r, err = NewDecryptingReader(r, kw); err != nil {
+			return nil, err
+		}
+	}
+
+	switch compression {
+	case GZip:
+		return gzip.NewReader(r)
+	case Snappy:
+		return snappy.NewReader(r), nil
+	default:
+		return r, nil
+	}
+}
@@ -0,0 +1,183 @@
+package jetcapture
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	_ Catalog = &BoltCatalog{}
+
+	boltBlocksBucket = []byte("blocks")
+	boltIDsBucket    = []byte("ids")
+)
+
+// BoltCatalog keeps the catalog in a local bbolt file. Entries are stored in a bucket per destination key and are
+// keyed by the start of the block or its oldest message, so time window queries only read the blocks up to the end of
+// the window. A second bucket maps block ids to their keys for replacing and removing entries.
+type BoltCatalog struct {
+	db *bbolt.DB
+}
+
+func OpenBoltCatalog(path string) (*BoltCatalog, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltBlocksBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltIDsBucket)
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &BoltCatalog{db: db}, nil
+}
+
+func (b *BoltCatalog) Close() error {
+	return b.db.Close()
+}
+
+// bucket names can't be empty, but destination keys can
+func boltDestKeyBucket(destKey string) []byte {
+	return []byte("k:" + destKey)
+}
+
+func boltEntryKey(start time.Time, id string) []byte {
+	return append(binary.BigEndian.AppendUint64(nil, uint64(start.UnixNano())), id...)
+}
+
+func (b *BoltCatalog) Add(_ context.Context, entry CatalogEntry) error {
+	v, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	from, _ := entry.bounds()
+	key := boltEntryKey(from, entry.ID)
+
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		name := boltDestKeyBucket(entry.DestKey)
+
+		bucket, err := tx.Bucket(boltBlocksBucket).CreateBucketIfNotExists(name)
+		if err != nil {
+			return err
+		}
+
+		ids, err := tx.Bucket(boltIDsBucket).CreateBucketIfNotExists(name)
+		if err != nil {
+			return err
+		}
+
+		// e.g. a block rewritten with late messages that are older than its previous oldest message
+		if old := ids.Get([]byte(entry.ID)); old != nil && !bytes.Equal(old, key) {
+			if err := bucket.Delete(old); err != nil {
+				return err
+			}
+		}
+
+		if err := ids.Put([]byte(entry.ID), key); err != nil {
+			return err
+		}
+
+		return bucket.Put(key, v)
+	})
+}
+
+func (b *BoltCatalog) Remove(_ context.Context, id string) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		root, ids := tx.Bucket(boltBlocksBucket), tx.Bucket(boltIDsBucket)
+
+		var names [][]byte
+
+		if err := ids.ForEachBucket(func(name []byte) error {
+			names = append(names, bytes.Clone(name))
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			keys := ids.Bucket(name)
+
+			key := keys.Get([]byte(id))
+			if key == nil {
+				continue
+			}
+
+			if bucket := root.Bucket(name); bucket != nil {
+				if err := bucket.Delete(key); err != nil {
+					return err
+				}
+			}
+
+			if err := keys.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (b *BoltCatalog) Query(_ context.Context, query CatalogQuery) ([]CatalogEntry, error) {
+	var entries []CatalogEntry
+
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(boltBlocksBucket)
+
+		var names [][]byte
+
+		if len(query.DestKeys) > 0 {
+			for _, dk := range query.DestKeys {
+				names = append(names, boltDestKeyBucket(dk))
+			}
+		} else if err := root.ForEachBucket(func(name []byte) error {
+			names = append(names, bytes.Clone(name))
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			bucket := root.Bucket(name)
+			if bucket == nil {
+				continue
+			}
+
+			c := bucket.Cursor()
+
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				var e CatalogEntry
+				if err := json.Unmarshal(v, &e); err != nil {
+					return err
+				}
+
+				// entries are sorted by their earliest time, so nothing after this can overlap the window
+				if from, _ := e.bounds(); !query.To.IsZero() && !from.Before(query.To) {
+					break
+				}
+
+				if query.Matches(e) {
+					entries = append(entries, e)
+				}
+			}
+		}
+
+		return nil
+	})
+
+	sortCatalogEntries(entries)
+
+	return entries, err
+}
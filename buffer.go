@@ -2,12 +2,16 @@ package jetcapture
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"os"
+	"sync/atomic"
 )
 
 type buffer interface {
 	io.ReadWriter
+	// Seek is only valid after `DoneWriting`. it allows a block to be re-read (e.g. by a retrying store)
+	io.Seeker
 	// TODO(jonathan): does `WriterTo` matter?
 	// io.WriterTo
 	DoneWriting() error
@@ -22,25 +26,129 @@ var (
 
 type memoryBuffer struct {
 	*bytes.Buffer
+	reader *bytes.Reader
+	usage  *atomic.Int64 // optional, shared by all buffers counting towards `Options.MaxMemoryBytes`
+	size   int64
+	disk   *diskBuffer // set once the buffer was spilled to disk
 }
 
 func newMemoryBuffer() buffer {
+	return newTrackedMemoryBuffer(nil)
+}
+
+// newTrackedMemoryBuffer returns a memory buffer that adds the size of its data to usage until it's removed or spilled
+func newTrackedMemoryBuffer(usage *atomic.Int64) *memoryBuffer {
 	return &memoryBuffer{
 		Buffer: &bytes.Buffer{},
+		usage:  usage,
+	}
+}
+
+func (m *memoryBuffer) Write(p []byte) (int, error) {
+	if m.disk != nil {
+		return m.disk.Write(p)
+	}
+
+	n, err := m.Buffer.Write(p)
+	m.track(int64(n))
+	return n, err
+}
+
+// DoneWriting switches reads over to a `bytes.Reader` so the data isn't consumed and can be re-read
+func (m *memoryBuffer) DoneWriting() error {
+	if m.disk != nil {
+		return m.disk.DoneWriting()
+	}
+
+	m.reader = bytes.NewReader(m.Bytes())
+	return nil
+}
+
+func (m *memoryBuffer) Read(p []byte) (int, error) {
+	if m.disk != nil {
+		return m.disk.Read(p)
 	}
+	if m.reader == nil {
+		return m.Buffer.Read(p)
+	}
+	return m.reader.Read(p)
+}
+
+func (m *memoryBuffer) Seek(offset int64, whence int) (int64, error) {
+	if m.disk != nil {
+		return m.disk.Seek(offset, whence)
+	}
+	if m.reader == nil {
+		return 0, errors.New("seek before DoneWriting")
+	}
+	return m.reader.Seek(offset, whence)
 }
 
-func (m *memoryBuffer) DoneWriting() error { return nil }
 func (m *memoryBuffer) Remove() error {
+	m.track(-m.size)
+
+	if m.disk != nil {
+		_ = m.disk.Close()
+		return m.disk.Remove()
+	}
+
 	m.Reset()
+	m.reader = nil
 	return nil
 }
 
+// spill moves the data written so far to a disk buffer in tmpRoot, which takes all further writes and adds its size
+// to spool
+func (m *memoryBuffer) spill(tmpRoot string, spool *atomic.Int64) error {
+	if m.disk != nil || m.reader != nil {
+		return errors.New("buffer can't be spilled")
+	}
+
+	disk, err := newTrackedDiskBuffer(tmpRoot, spool)
+	if err != nil {
+		return err
+	}
+
+	if _, err := disk.Write(m.Bytes()); err != nil {
+		_ = disk.Close()
+		_ = disk.Remove()
+		return err
+	}
+
+	m.track(-m.size)
+	m.disk = disk
+	m.Buffer = &bytes.Buffer{}
+
+	return nil
+}
+
+func (m *memoryBuffer) track(n int64) {
+	m.size += n
+	if m.usage != nil {
+		m.usage.Add(n)
+	}
+}
+
 type diskBuffer struct {
 	*os.File
+	usage *atomic.Int64 // optional, shared by all buffers counting towards `Options.MaxSpoolBytes`
+	size  int64
+}
+
+func (d *diskBuffer) Write(p []byte) (int, error) {
+	n, err := d.File.Write(p)
+	d.size += int64(n)
+	if d.usage != nil {
+		d.usage.Add(int64(n))
+	}
+	return n, err
 }
 
 func (d *diskBuffer) Remove() error {
+	if d.usage != nil {
+		d.usage.Add(-d.size)
+	}
+	d.size = 0
 	return os.Remove(d.Name())
 }
 
@@ -54,12 +162,17 @@ func (d *diskBuffer) DoneWriting() error {
 }
 
 func newDiskBuffer(tmpRoot string) (buffer, error) {
+	return newTrackedDiskBuffer(tmpRoot, nil)
+}
+
+// newTrackedDiskBuffer returns a disk buffer that adds the size of its data to usage until it's removed
+func newTrackedDiskBuffer(tmpRoot string, usage *atomic.Int64) (*diskBuffer, error) {
 	f, err := os.CreateTemp(tmpRoot, "capture-*")
 	if err != nil {
 		return nil, err
 	}
 	log.Debugf("created %s", f.Name())
-	return &diskBuffer{File: f}, nil
+	return &diskBuffer{File: f, usage: usage}, nil
 }
 
 // wrappedWriter wraps an underlying buffer. it also _closes_ the "top" writer upon a call to `DoneWriting`
@@ -1,14 +1,20 @@
 package jetcapture
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
-	"github.com/pkg/errors"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/klauspost/compress/gzip"
-	"github.com/klauspost/compress/snappy"
 	"github.com/nats-io/nats.go"
+	"github.com/oklog/ulid/v2"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -34,6 +40,7 @@ type message[P Payload, K DestKey] struct {
 	msg     *nats.Msg
 	Payload P
 	DestKey K
+	Time    time.Time // decides the block, see `Options.TimeExtractor`
 }
 
 func (m *message[P, K]) RawMessage() *nats.Msg {
@@ -41,33 +48,74 @@ func (m *message[P, K]) RawMessage() *nats.Msg {
 }
 
 type Capture[P Payload, K DestKey] struct {
-	opts Options[P, K]
-	nc   *nats.Conn
-	js   nats.JetStreamContext
+	opts  Options[P, K]
+	nc    *nats.Conn
+	js    nats.JetStreamContext
+	acker acker // publishes the acks, i.e. nc
 
-	fetched int
-	acked   int
+	stats     Stats
+	pausedErr error // why fetching is paused, see Health
+	statsMu   sync.Mutex
 
 	blocks map[K][]*dataBlock[P]
 
+	// finalized keeps the intervals blocks were finalized for, which later messages are late for. stored keeps the
+	// most recently stored block of each interval for LateRewrite.
+	finalized map[K]map[time.Time]time.Time // start -> end
+	stored    map[K]map[time.Time]storedBlock
+
+	// newestMessage is the time of the newest message, bounded by its stream timestamp. newestStream is the newest
+	// stream timestamp, which backfilled blocks age by (see blockExpired)
 	newestMessage time.Time
+	newestStream  time.Time
+
+	// consumer is the last consumer info, which is read again every `Options.ConsumerRefresh`
+	consumer   *nats.ConsumerInfo
+	consumerAt time.Time
+	// batch is the current fetch batch of AdaptiveFetch
+	batch int
+
+	// memory and spool are the sizes of all in-memory and temp file block buffers, see `Options.MaxMemoryBytes` and
+	// `Options.MaxSpoolBytes`
+	memory atomic.Int64
+	spool  atomic.Int64
 
 	start time.Time
 }
 
 func New[P Payload, K DestKey](opts Options[P, K]) *Capture[P, K] {
 	return &Capture[P, K]{
-		opts:   opts,
-		blocks: map[K][]*dataBlock[P]{},
+		opts:      opts,
+		blocks:    map[K][]*dataBlock[P]{},
+		finalized: map[K]map[time.Time]time.Time{},
+		stored:    map[K]map[time.Time]storedBlock{},
 	}
 }
 
+// exactlyOnceBlockID derives the block id from what identifies the block's messages, so a block written again after
+// its messages were redelivered gets the same name. The id is still a valid ULID.
+func exactlyOnceBlockID[K DestKey](stream string, dk K, start time.Time, firstSeq uint64) string {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%s\x00%v\x00%d\x00%d", stream, dk, start.UnixNano(), firstSeq)
+	return ulid.MustNew(ulid.Timestamp(start), bytes.NewReader(h.Sum(nil))).String()
+}
+
+// lateHorizon limits how far back finalized intervals and stored blocks are remembered. Older messages get a new
+// block, and aren't counted as late.
+const lateHorizon = 24 * time.Hour
+
+type storedBlock struct {
+	fileName string
+	manifest BlockManifest
+}
+
 func (c *Capture[P, K]) Run(ctx context.Context, nc *nats.Conn) (err error) {
 	if err := c.opts.Validate(); err != nil {
 		return err
 	}
 
 	c.nc = nc
+	c.acker = nc
 
 	// quick ping to test the connection
 	if err := c.nc.Flush(); err != nil {
@@ -97,6 +145,8 @@ func (c *Capture[P, K]) Run(ctx context.Context, nc *nats.Conn) (err error) {
 		return err
 	}
 
+	c.setConsumer(cinfo)
+
 	// TODO(jonathan): check acktimeout and compare to opts.MaxAge
 
 	sub, err := c.js.PullSubscribe(_EMPTY_, c.opts.NATSConsumerName, nats.Bind(c.opts.NATSStreamName, c.opts.NATSConsumerName))
@@ -107,8 +157,8 @@ func (c *Capture[P, K]) Run(ctx context.Context, nc *nats.Conn) (err error) {
 	defer func() {
 		c.sweepBlocks(ctx, true)
 
-		if c.fetched != c.acked {
-			log.Warnf("fetched: %d, acked: %d", c.fetched, c.acked)
+		if stats := c.Stats(); stats.Fetched != stats.Acked {
+			log.Warnf("fetched: %d, acked: %d", stats.Fetched, stats.Acked)
 		}
 
 		log.Infof("unsubscribing...")
@@ -138,9 +188,20 @@ func (c *Capture[P, K]) Run(ctx context.Context, nc *nats.Conn) (err error) {
 		default:
 		}
 
+		if c.limitSpool(ctx) {
+			// fetching would only fill the spool further. keep sweeping so due blocks still get stored
+			select {
+			case <-ctx.Done():
+			case <-time.After(spoolPauseInterval):
+			}
+
+			c.sweepBlocks(ctx, false)
+			continue
+		}
+
 		forceFlush := false
 
-		if err := c.fetch(ctx, sub, cinfo.Config.MaxRequestBatch); err != nil {
+		if err := c.fetch(ctx, sub, c.fetchBatch(c.nextBatch())); err != nil {
 			switch err {
 			// canceled (e.g. CTRL-C)
 			case context.Canceled:
@@ -156,6 +217,9 @@ func (c *Capture[P, K]) Run(ctx context.Context, nc *nats.Conn) (err error) {
 					return err
 				}
 
+				c.setConsumer(ci)
+				c.limitBatchToAckRoom(ci)
+
 				if ci.NumAckPending >= int(float64(ci.Config.MaxAckPending)*0.95) {
 					forceFlush = true
 				}
@@ -168,6 +232,169 @@ func (c *Capture[P, K]) Run(ctx context.Context, nc *nats.Conn) (err error) {
 		}
 
 		c.sweepBlocks(ctx, forceFlush)
+		c.limitMemory(ctx)
+		c.refreshConsumer(ctx)
+	}
+}
+
+// spoolPauseInterval is how often the spool is checked again while fetching is paused
+const spoolPauseInterval = time.Second
+
+// spoolFull returns why the temp files of the blocks need to shrink, if they do
+func (c *Capture[P, K]) spoolFull() error {
+	if limit := c.opts.MaxSpoolBytes; limit > 0 {
+		if used := c.spool.Load(); used > limit {
+			return fmt.Errorf("spool uses %d bytes, more than MaxSpoolBytes %d", used, limit)
+		}
+	}
+
+	// without temp files, storing blocks early can't free anything
+	if c.opts.MinFreeSpoolBytes > 0 && (c.opts.BufferToDisk || c.spool.Load() > 0) {
+		tempDir := c.opts.TempDir
+		if tempDir == _EMPTY_ {
+			tempDir = os.TempDir()
+		}
+
+		free, err := diskFree(tempDir)
+		if err != nil {
+			log.Warnw("unable to check free space", "dir", tempDir, "error", err)
+			return nil
+		}
+
+		if free < c.opts.MinFreeSpoolBytes {
+			return fmt.Errorf("%s has %d bytes free, less than MinFreeSpoolBytes %d", tempDir, free, c.opts.MinFreeSpoolBytes)
+		}
+	}
+
+	return nil
+}
+
+// limitSpool stores the blocks with the largest temp files early until the spool is within `Options.MaxSpoolBytes`
+// and `Options.MinFreeSpoolBytes` again. It returns true if fetching should pause, because the spool is still full.
+func (c *Capture[P, K]) limitSpool(ctx context.Context) bool {
+	if c.opts.MaxSpoolBytes <= 0 && c.opts.MinFreeSpoolBytes == 0 {
+		return false
+	}
+
+	for {
+		full := c.spoolFull()
+		if full == nil {
+			c.setPaused(nil)
+			return false
+		}
+
+		var (
+			dk    K
+			block *dataBlock[P]
+			index int
+		)
+
+		for k, blocks := range c.blocks {
+			for i, b := range blocks {
+				if size := b.spoolSize(); size > 0 && (block == nil || size > block.spoolSize()) {
+					dk, block, index = k, b, i
+				}
+			}
+		}
+
+		if block == nil {
+			c.setPaused(full)
+			return true
+		}
+
+		log.Infow("spool full, storing block early", "reason", full, "block", block.id, "size", block.spoolSize())
+
+		c.blocks[dk] = slices.Delete(c.blocks[dk], index, index+1)
+
+		if err := c.finalizeBlock(ctx, block, dk); err != nil {
+			log.Error(err)
+		}
+
+		c.updateStats(func(s *Stats) { s.SpoolFlushes++ })
+	}
+}
+
+func (c *Capture[P, K]) setPaused(err error) {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	if (err == nil) != (c.pausedErr == nil) {
+		if err != nil {
+			log.Warnw("pausing fetch", "reason", err)
+		} else {
+			log.Infow("resuming fetch")
+		}
+	}
+
+	c.pausedErr = err
+	c.stats.FetchPaused = err != nil
+}
+
+// fetchBatch shrinks the fetch batch once more than half of `Options.MaxMemoryBytes` is used, down to a single
+// message at the limit, so a burst can't outrun limitMemory
+func (c *Capture[P, K]) fetchBatch(batchSz int) int {
+	limit := c.opts.MaxMemoryBytes
+	if limit <= 0 || batchSz <= 1 {
+		return batchSz
+	}
+
+	free := limit - c.memory.Load()
+	if free*2 >= limit {
+		return batchSz
+	}
+
+	return max(int(int64(batchSz)*free*2/limit), 1)
+}
+
+// limitMemory spills or stores blocks (see `Options.MemoryPolicy`) until the in-memory buffers fit in
+// `Options.MaxMemoryBytes` again
+func (c *Capture[P, K]) limitMemory(ctx context.Context) {
+	if c.opts.MaxMemoryBytes <= 0 {
+		return
+	}
+
+	for c.memory.Load() > c.opts.MaxMemoryBytes {
+		var (
+			dk    K
+			block *dataBlock[P]
+			index int
+		)
+
+		// spill the largest blocks, which frees the most memory, or store the oldest ones, which are due first anyway
+		for k, blocks := range c.blocks {
+			for i, b := range blocks {
+				if b.memorySize() == 0 {
+					continue
+				}
+
+				switch {
+				case block == nil,
+					c.opts.MemoryPolicy == MemorySpill && b.memorySize() > block.memorySize(),
+					c.opts.MemoryPolicy == MemoryFinalize && b.start.Before(block.start):
+					dk, block, index = k, b, i
+				}
+			}
+		}
+
+		if block == nil {
+			return
+		}
+
+		log.Infow("memory limit exceeded", "policy", c.opts.MemoryPolicy, "used", c.memory.Load(),
+			"limit", c.opts.MaxMemoryBytes, "block", block.id, "size", block.memorySize())
+
+		if c.opts.MemoryPolicy == MemoryFinalize {
+			c.blocks[dk] = slices.Delete(c.blocks[dk], index, index+1)
+
+			if err := c.finalizeBlock(ctx, block, dk); err != nil {
+				log.Error(err)
+			}
+		} else if err := block.base.(*memoryBuffer).spill(c.opts.TempDir, &c.spool); err != nil {
+			log.Errorw("unable to spill block to disk", "block", block.id, "error", err)
+			return
+		}
+
+		c.updateStats(func(s *Stats) { s.MemoryFlushes++ })
 	}
 }
 
@@ -178,12 +405,45 @@ func (c *Capture[P, K]) consumerInfo(ctx context.Context) (*nats.ConsumerInfo, e
 	return c.js.ConsumerInfo(c.opts.NATSStreamName, c.opts.NATSConsumerName, nats.Context(ctx))
 }
 
+// intervalExpired reports whether blocks for the interval ending at end are due. This is the case once a message
+// newer than end plus FlushGrace was seen, or with IdleFlush, once the wall clock passes that point. Note that message
+// timestamps are set by the NATS server, so IdleFlush is subject to clock skew between the server and this host.
+func (c *Capture[P, K]) intervalExpired(end time.Time, now time.Time) bool {
+	deadline := end.Add(c.opts.FlushGrace)
+
+	if c.newestMessage.After(deadline) {
+		return true
+	}
+
+	return c.opts.IdleFlush && now.After(deadline)
+}
+
+// blockExpired reports whether a block is due. With a TimeExtractor, a block opened after its interval ended (e.g. for
+// a device publishing buffered messages) ages from when it was opened instead, and stays open for the length of its
+// window in stream time. Otherwise live traffic would expire it on the next sweep, and make every further message of
+// the backfill late.
+func (c *Capture[P, K]) blockExpired(b *dataBlock[P], now time.Time) bool {
+	if c.opts.TimeExtractor == nil || !b.opened.After(b.end.Add(c.opts.FlushGrace)) {
+		return c.intervalExpired(b.end, now)
+	}
+
+	deadline := b.opened.Add(b.end.Sub(b.start) + c.opts.FlushGrace)
+
+	if c.newestStream.After(deadline) {
+		return true
+	}
+
+	return c.opts.IdleFlush && now.After(deadline)
+}
+
 func (c *Capture[P, K]) sweepBlocks(ctx context.Context, forceFlush bool) {
+	now := time.Now()
+
 	for dk, v := range c.blocks {
 		var keep []*dataBlock[P]
 
 		for _, b := range v {
-			if forceFlush || c.newestMessage.After(b.start.Add(c.opts.MaxAge)) || (c.opts.MaxMessages > 0 && b.messageCount >= c.opts.MaxMessages) {
+			if forceFlush || c.blockExpired(b, now) || (c.opts.MaxMessages > 0 && b.messageCount >= c.opts.MaxMessages) {
 				if err := c.finalizeBlock(ctx, b, dk); err != nil {
 					log.Error(err)
 				}
@@ -195,13 +455,41 @@ func (c *Capture[P, K]) sweepBlocks(ctx context.Context, forceFlush bool) {
 		c.blocks[dk] = keep
 	}
 
+	horizon := c.newestMessage.Add(-lateHorizon)
+
+	for dk, intervals := range c.finalized {
+		for start, end := range intervals {
+			if end.Before(horizon) {
+				delete(intervals, start)
+			}
+		}
+
+		if len(intervals) == 0 {
+			delete(c.finalized, dk)
+		}
+	}
+
+	for dk, intervals := range c.stored {
+		for start, sb := range intervals {
+			if sb.manifest.End.Before(horizon) {
+				delete(intervals, start)
+			}
+		}
+
+		if len(intervals) == 0 {
+			delete(c.stored, dk)
+		}
+	}
+
 	c.debugPrint(fmt.Sprintf("sweep done flush=%v", forceFlush))
 }
 
 func (c *Capture[P, K]) finalizeBlock(ctx context.Context, block *dataBlock[P], dk K) error {
-	defer func() {
-		_ = block.buffer.Remove()
-	}()
+	if block.buffer != nil {
+		defer func() {
+			_ = block.buffer.Remove()
+		}()
+	}
 
 	if err := block.close(); err != nil {
 		return err
@@ -214,36 +502,143 @@ func (c *Capture[P, K]) finalizeBlock(ctx context.Context, block *dataBlock[P],
 		err error
 	)
 
-	if c.opts.OnStoreComplete != nil {
-		defer func() {
-			c.opts.OnStoreComplete(dk, p, n, dur, err)
-		}()
+	// blocks that only contain dropped messages are not stored, but still need to be acked
+	if block.messageCount > 0 {
+		if c.opts.OnStoreComplete != nil {
+			reporter := &storeReporter[K]{onStoreComplete: c.opts.OnStoreComplete}
+			ctx = withStoreReporter(ctx, reporter)
+
+			defer func() {
+				// stores like MultiStore already reported each of their writes
+				if !reporter.taken {
+					c.opts.OnStoreComplete(dk, p, n, dur, err)
+				}
+			}()
+		}
+
+		var fileName string
+
+		manifest := block.Manifest()
+
+		if block.rewrite != nil {
+			fileName = block.rewrite.fileName
+
+			rw := c.opts.Store.(BlockRewriter[K])
+			p, n, dur, err = rw.Rewrite(ctx, block, dk, block.path(), fileName)
+
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				// the stored block is gone (e.g. pruned or compacted), so the late messages get a block of their own
+				log.Warnw("stored block not found, writing a new block", "path", p, "error", err)
+
+				delete(c.stored[dk], block.start)
+				block.rewrite = nil
+				block.id = newBlockID(block.start)
+
+				if _, err = block.Seek(0, io.SeekStart); err != nil {
+					return err
+				}
+			case err != nil:
+				return err
+			default:
+				manifest = block.rewrite.manifest.merge(manifest)
+			}
+		}
+
+		if block.rewrite == nil {
+			prefix := "backup"
+			if block.late && c.opts.LatePolicy == LateSeparate {
+				prefix = "late"
+			}
+
+			if c.opts.ExactlyOnce {
+				block.id = exactlyOnceBlockID(c.opts.NATSStreamName, dk, block.start, block.firstSeq)
+			}
+
+			fileName = block.fileName(prefix, c.fileSuffix())
+			manifest = block.Manifest()
+
+			if p, n, dur, err = c.opts.Store.Write(ctx, block, dk, block.path(), fileName); err != nil {
+				if !c.opts.ExactlyOnce || !onlyErrExist(err) {
+					return err
+				}
+				// a previous run stored the same block, but its acks got lost
+				log.Infow("block already stored, skipping", "path", p, "id", block.id)
+				err = nil
+			}
+		}
+
+		if c.opts.LatePolicy == LateRewrite {
+			if c.stored[dk] == nil {
+				c.stored[dk] = map[time.Time]storedBlock{}
+			}
+			c.stored[dk][block.start] = storedBlock{fileName: fileName, manifest: manifest}
+		}
+
+		if c.opts.Catalog != nil {
+			// the block is stored, so it's still acked. a missing catalog entry only makes it harder to find
+			if cerr := c.opts.Catalog.Add(ctx, NewCatalogEntry(fmt.Sprint(dk), p, n, manifest)); cerr != nil {
+				log.Errorw("unable to add block to catalog", "path", p, "error", cerr)
+			}
+		}
 	}
 
-	if p, n, dur, err = c.opts.Store.Write(ctx, block, dk, block.path(), block.fileName("backup", c.fileSuffix())); err != nil {
-		return err
+	// only stored intervals count, as messages of a block that failed to store are redelivered without being late
+	if c.finalized[dk] == nil {
+		c.finalized[dk] = map[time.Time]time.Time{}
 	}
+	c.finalized[dk][block.start] = block.end
+
+	acked, err := block.ackAll(c.acker)
+
+	c.updateStats(func(s *Stats) {
+		s.Acked += acked
+	})
 
-	acked, err := block.ackAll(c.nc)
 	if err != nil {
 		return err
 	}
 
-	c.acked += acked
-
 	return nil
 }
 
+// onlyErrExist reports whether err is `os.ErrExist`, or joins nothing but `os.ErrExist` errors (e.g. a store writing
+// to several others, which all had the block already)
+func onlyErrExist(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := joined.Unwrap()
+		for _, e := range errs {
+			if !onlyErrExist(e) {
+				return false
+			}
+		}
+		return len(errs) > 0
+	}
+
+	if inner := errors.Unwrap(err); inner != nil {
+		return onlyErrExist(inner)
+	}
+
+	return errors.Is(err, os.ErrExist)
+}
+
 func (c *Capture[P, K]) fileSuffix() string {
-	suffix := c.opts.Suffix
+	return blockFileSuffix(c.opts.Suffix, c.opts.Compression, c.opts.Encryption != nil)
+}
 
-	switch c.opts.Compression {
+// blockFileSuffix appends the compression and encryption extensions to a format suffix (see ParseBlockFileName)
+func blockFileSuffix(suffix string, compression Compression, encrypted bool) string {
+	switch compression {
 	case Snappy:
 		suffix += ".snappy"
 	case GZip:
 		suffix += ".gz"
 	}
 
+	if encrypted {
+		suffix += ".enc"
+	}
+
 	return suffix
 }
 
@@ -268,121 +663,367 @@ func (c *Capture[P, K]) safeDecode(msg *nats.Msg) (payload P, dk K, err error) {
 	return c.opts.MessageDecoder(msg)
 }
 
+// messageTime extracts the time of a message using `Options.TimeExtractor`, and checks it against the bounds
+func (c *Capture[P, K]) messageTime(m *nats.Msg, md *nats.MsgMetadata, payload P) (ts time.Time, err error) {
+	defer func() {
+		if rerr := recover(); rerr != nil {
+			err = fmt.Errorf("panic during time extraction: %+v", rerr)
+		}
+	}()
+
+	if ts, err = c.opts.TimeExtractor(m, md, payload); err != nil {
+		return ts, err
+	}
+
+	// partitions are rendered in the location of the stream timestamp, whatever zone the extracted time came with
+	ts = ts.In(md.Timestamp.Location())
+
+	if c.opts.MaxPastTime > 0 && ts.Before(md.Timestamp.Add(-c.opts.MaxPastTime)) {
+		return ts, fmt.Errorf("message time %s is more than %s before the stream time", ts, c.opts.MaxPastTime)
+	}
+
+	if c.opts.MaxFutureTime > 0 && ts.After(md.Timestamp.Add(c.opts.MaxFutureTime)) {
+		return ts, fmt.Errorf("message time %s is more than %s after the stream time", ts, c.opts.MaxFutureTime)
+	}
+
+	return ts, nil
+}
+
+// transform applies `Options.Transforms` in order, counting the errors of each
+func (c *Capture[P, K]) transform(payload P) (P, error) {
+	for i, t := range c.opts.Transforms {
+		var err error
+
+		if payload, err = safeTransform(t, payload); err != nil {
+			c.updateStats(func(s *Stats) {
+				for len(s.TransformErrors) <= i {
+					s.TransformErrors = append(s.TransformErrors, 0)
+				}
+				s.TransformErrors[i]++
+			})
+			return payload, fmt.Errorf("transform %d: %w", i, err)
+		}
+	}
+
+	return payload, nil
+}
+
+func safeTransform[P Payload](t Transform[P], payload P) (_ P, err error) {
+	defer func() {
+		if rerr := recover(); rerr != nil {
+			err = fmt.Errorf("panic during transform: %+v", rerr)
+		}
+	}()
+
+	return t(payload)
+}
+
+// messageFailed is called when a message can't be processed. The message is not acked and will be redelivered once
+// the consumer's AckWait expires.
+func (c *Capture[P, K]) messageFailed(m *nats.Msg, md *nats.MsgMetadata, err error) {
+	c.updateStats(func(s *Stats) {
+		s.Failed++
+	})
+
+	log.With(
+		"subject", m.Subject,
+		"stream", md.Stream,
+		"consumer", md.Consumer,
+		"timestamp", md.Timestamp,
+		"seq.consumer", md.Sequence.Consumer,
+		"seq.stream", md.Sequence.Stream,
+	).Errorf("unable to process due to err=%v", err)
+
+	if c.opts.OnMessageError != nil {
+		c.opts.OnMessageError(m, err)
+	}
+}
+
 func (c *Capture[P, K]) fetch(ctx context.Context, sub *nats.Subscription, batchSz int) error {
-	// TODO(jonathan): background ctx? what should the timeout be?
-	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	fetchCtx, cancel := context.WithTimeout(ctx, c.fetchTimeout())
 	defer cancel()
 
+	opts := []nats.PullOpt{nats.Context(fetchCtx)}
+	if maxBytes := c.fetchMaxBytes(); maxBytes > 0 {
+		opts = append(opts, nats.PullMaxBytes(maxBytes))
+	}
+
+	start := time.Now()
+
 	// note: fetch will return err == nil if len(messages) > 0
-	messages, err := sub.Fetch(batchSz, nats.Context(ctx))
-	c.fetched += len(messages)
+	messages, err := sub.Fetch(batchSz, opts...)
+	c.updateStats(func(s *Stats) {
+		s.Fetched += len(messages)
+		s.FetchBatch = batchSz
+	})
+
+	c.adaptBatch(batchSz, len(messages), time.Since(start))
 
 	if err != nil {
 		return err
 	}
 
-	for _, m := range messages {
+	c.handleBatch(ctx, messages)
+
+	return nil
+}
+
+// spoolCheckBytes is how much message data is handled between checks of the spool while handling a batch
+const spoolCheckBytes = 1 << 20
+
+// handleBatch handles fetched messages. A batch can fill the spool by itself, so it's checked as blocks grow rather
+// than only between fetches. Once it's full, the rest of the batch is nacked, to be redelivered once fetching resumes.
+func (c *Capture[P, K]) handleBatch(ctx context.Context, messages []*nats.Msg) {
+	var unchecked int
+
+	for i, m := range messages {
 		md, _ := m.Metadata()
+		c.handle(m, md)
 
-		if md.Timestamp.After(c.newestMessage) {
-			c.newestMessage = md.Timestamp
+		if unchecked += len(m.Data); unchecked < spoolCheckBytes {
+			continue
 		}
 
-		decoded, dk, err := c.safeDecode(m)
-		if err != nil {
-			md, _ := m.Metadata()
-			log.With(
-				"subject", m.Subject,
-				"stream", md.Stream,
-				"consumer", md.Consumer,
-				"timestamp", md.Timestamp,
-				"seq.consumer", md.Sequence.Consumer,
-				"seq.stream", md.Sequence.Stream,
-			).Errorf("unable to process due to err=%v", err)
-			continue
+		unchecked = 0
+
+		if c.limitSpool(ctx) {
+			c.nakAll(messages[i+1:], spoolPauseInterval)
+			return
 		}
+	}
+}
 
-		msg := &message[P, K]{
-			msg:     m,
-			Payload: decoded,
-			DestKey: dk,
+// nakAll asks for the messages to be redelivered after delay
+func (c *Capture[P, K]) nakAll(messages []*nats.Msg, delay time.Duration) {
+	if len(messages) == 0 {
+		return
+	}
+
+	log.Warnw("spool full, nacking the rest of the batch", "messages", len(messages))
+
+	nak := []byte(fmt.Sprintf("-NAK {\"delay\": %d}", delay.Nanoseconds()))
+	nacked := 0
+
+	for _, m := range messages {
+		if err := c.acker.Publish(m.Reply, nak); err != nil {
+			log.Errorf("nak error: %v", err)
+		} else {
+			nacked++
 		}
+	}
 
-		block, err := c.findBlock(msg, md)
-		if err != nil {
-			log.Error(err)
-			continue
+	if err := c.acker.Flush(); err != nil {
+		log.Errorf("nak error: %v", err)
+	}
+
+	c.updateStats(func(s *Stats) { s.Nacked += nacked })
+}
+
+// handle decodes, filters and transforms a fetched message and writes it to its block
+func (c *Capture[P, K]) handle(m *nats.Msg, md *nats.MsgMetadata) {
+	decoded, dk, err := c.safeDecode(m)
+	if err != nil {
+		c.messageFailed(m, md, err)
+		return
+	}
 
+	msg := &message[P, K]{
+		msg:     m,
+		Payload: decoded,
+		DestKey: dk,
+		Time:    md.Timestamp,
+	}
+
+	action := FilterKeep
+
+	if c.opts.TimeExtractor != nil {
+		ts, err := c.messageTime(m, md, decoded)
+
+		switch {
+		case err == nil:
+			msg.Time = ts
+		case c.opts.TimeFallback == TimeFallbackFail:
+			c.updateStats(func(s *Stats) { s.TimeFallbacks++ })
+			c.messageFailed(m, md, err)
+			return
+		default:
+			c.updateStats(func(s *Stats) { s.TimeFallbacks++ })
+			log.Debugw("using stream time", "subject", m.Subject, "seq.stream", md.Sequence.Stream, "error", err)
+			if c.opts.TimeFallback == TimeFallbackDrop {
+				action = FilterDrop
+			}
 		}
+	}
+
+	// the stream timestamp bounds the clock, so a bogus time far in the future can't flush every block and make all
+	// following messages late
+	clock := msg.Time
+	if clock.After(md.Timestamp) {
+		clock = md.Timestamp
+	}
+
+	if clock.After(c.newestMessage) {
+		c.newestMessage = clock
+	}
+
+	if md.Timestamp.After(c.newestStream) {
+		c.newestStream = md.Timestamp
+	}
+
+	if c.opts.Filter != nil && action != FilterDrop {
+		var routed K
+
+		switch action, routed = c.opts.Filter(m, decoded, dk); action {
+		case FilterDrop:
+			c.updateStats(func(s *Stats) { s.Dropped++ })
+		case FilterRoute:
+			c.updateStats(func(s *Stats) { s.Routed++ })
+			msg.DestKey = routed
+		}
+	}
+
+	if action != FilterDrop && len(c.opts.Transforms) > 0 {
+		if msg.Payload, err = c.transform(msg.Payload); err != nil {
+			c.messageFailed(m, md, err)
+			return
+		}
+	}
+
+	block := c.findBlock(msg)
+
+	if action == FilterDrop {
+		block.skip(m.Reply, msg.Time)
+		return
+	}
+
+	if block.written(md.Sequence.Stream) {
+		c.updateStats(func(s *Stats) { s.Duplicates++ })
+		block.skip(m.Reply, msg.Time)
+		return
+	}
 
-		if err := block.write(msg.Payload, m.Reply, md); err != nil {
+	if block.buffer == nil {
+		buf, base, err := c.makeBuffer()
+		if err != nil {
 			log.Error(err)
-			continue
+			return
 		}
+		block.open(buf, base)
 	}
 
-	return nil
+	if err := block.write(msg.Payload, m.Reply, msg.Time, md); err != nil {
+		log.Error(err)
+	}
 }
 
-func (c *Capture[P, K]) findBlock(msg *message[P, K], md *nats.MsgMetadata) (*dataBlock[P], error) {
+// findBlock returns the block msg belongs to, creating it if needed. Its buffer is only created once a message is
+// written to it.
+func (c *Capture[P, K]) findBlock(msg *message[P, K]) *dataBlock[P] {
 	dk := msg.DestKey
 
-	start := md.Timestamp.Truncate(c.opts.MaxAge)
+	start := c.opts.Window.Start(msg.Time)
 
-	var block *dataBlock[P]
+	var (
+		late    bool
+		rewrite *storedBlock
+	)
 
-	if _, ok := c.blocks[dk]; !ok {
-		c.blocks[dk] = []*dataBlock[P]{}
-	} else {
-		for _, b := range c.blocks[dk] {
-			if b.start.Equal(start) {
-				block = b
-				break
+	block := c.openBlock(dk, start, false)
+
+	// the interval's blocks were finalized, and newer messages arrived since. blocks finalized early (e.g. by
+	// MaxMessages, or IdleFlush while catching up on a backlog) get a new block as long as their interval is current.
+	if end, ok := c.finalized[dk][start]; ok && block == nil && c.newestMessage.After(end.Add(c.opts.FlushGrace)) {
+		c.updateStats(func(s *Stats) { s.Late++ })
+
+		late = true
+
+		switch c.opts.LatePolicy {
+		case LateSeparate:
+			start = c.opts.Window.Start(c.newestMessage)
+		case LateAppend:
+			start = c.opts.Window.Start(c.newestMessage)
+			late = false
+		case LateRewrite:
+			if sb, ok := c.stored[dk][start]; ok {
+				rewrite = &sb
 			}
 		}
+
+		block = c.openBlock(dk, start, late)
 	}
 
 	if block == nil {
 		// log.Debug("creating a new block...")
-		buf, err := c.makeBuffer()
-		if err != nil {
-			return nil, err
+		block = newDataBlock[P](start, c.opts.WriterFactory())
+		block.end = c.opts.Window.End(start)
+		block.compression = c.opts.Compression
+		block.encrypted = c.opts.Encryption != nil
+		block.late = late
+		block.opened = c.newestStream
+		if c.opts.ExactlyOnce {
+			block.seqs = map[uint64]struct{}{}
+		}
+		if rewrite != nil {
+			// the merged block keeps the identity of the stored block
+			block.id = rewrite.manifest.ID
+			block.rewrite = rewrite
 		}
-		block = newDataBlock[P](start, c.opts.WriterFactory(), buf)
 		c.blocks[dk] = append(c.blocks[dk], block)
 	}
 
-	return block, nil
+	return block
 }
 
-func (c *Capture[P, K]) makeBuffer() (buffer, error) {
-	var (
-		buf buffer
-		err error
-	)
+// openBlock returns the block for the interval that hasn't been finalized yet, if any
+func (c *Capture[P, K]) openBlock(dk K, start time.Time, late bool) *dataBlock[P] {
+	for _, b := range c.blocks[dk] {
+		if b.start.Equal(start) && b.late == late {
+			return b
+		}
+	}
+	return nil
+}
+
+// makeBuffer returns the buffer for a new block, and the memoryBuffer or diskBuffer at the bottom of it
+func (c *Capture[P, K]) makeBuffer() (buffer, buffer, error) {
+	var base buffer
 
 	if c.opts.BufferToDisk {
-		if buf, err = newDiskBuffer(c.opts.TempDir); err != nil {
-			return nil, err
+		disk, err := newTrackedDiskBuffer(c.opts.TempDir, &c.spool)
+		if err != nil {
+			return nil, nil, err
 		}
+		base = disk
 	} else {
-		buf = newMemoryBuffer()
+		base = newTrackedMemoryBuffer(&c.memory)
 	}
 
-	switch c.opts.Compression {
-	case Snappy:
+	buf, err := wrapBuffer(base, c.opts.Compression, c.opts.Encryption)
+
+	return buf, base, err
+}
+
+// wrapBuffer layers the optional encryption and compression writers on top of a buffer
+func wrapBuffer(buf buffer, compression Compression, kw KeyWrapper) (buffer, error) {
+	// encryption sits below compression, encrypting the already compressed data
+	if kw != nil {
+		wr, err := newEncryptingWriter(buf, kw)
+		if err != nil {
+			_ = buf.Remove()
+			return nil, err
+		}
+
 		buf = &wrappedWriter{
 			buffer: buf,
-			wr:     snappy.NewBufferedWriter(buf),
+			wr:     wr,
 		}
-	case GZip:
+	}
+
+	if compression != None {
 		buf = &wrappedWriter{
 			buffer: buf,
-			wr:     gzip.NewWriter(buf),
+			wr:     newCompressingWriter(buf, compression),
 		}
-	case None:
-	default:
-		panic("unhandled compression type")
 	}
 
 	return buf, nil
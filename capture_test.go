@@ -6,9 +6,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
@@ -357,5 +361,842 @@ func TestCapture(t *testing.T) {
 
 	assert.Equal(26, fileCount)
 	assert.Equal(cfg.messages-expectedErrors, rowCount)
-	assert.Equal(capture.fetched-capture.acked, expectedErrors)
+	stats := capture.Stats()
+	assert.Equal(stats.Fetched-stats.Acked, expectedErrors)
+}
+
+func TestIntervalExpired(t *testing.T) {
+	assert := require.New(t)
+
+	end := time.Date(2024, 1, 2, 3, 15, 0, 0, time.UTC)
+
+	c := New[string, string](Options[string, string]{MaxAge: 15 * time.Minute, FlushGrace: time.Minute})
+
+	c.newestMessage = end.Add(30 * time.Second)
+	assert.False(c.intervalExpired(end, end.Add(time.Hour)))
+
+	c.newestMessage = end.Add(61 * time.Second)
+	assert.True(c.intervalExpired(end, end))
+
+	// wall-clock time only counts with IdleFlush
+	c.newestMessage = end.Add(-time.Minute)
+	assert.False(c.intervalExpired(end, end.Add(time.Hour)))
+
+	c.opts.IdleFlush = true
+	assert.False(c.intervalExpired(end, end.Add(59*time.Second)))
+	assert.True(c.intervalExpired(end, end.Add(61*time.Second)))
+}
+
+func TestCaptureIdleFlush(t *testing.T) {
+	assert := require.New(t)
+
+	cfg := captureTestConfig{
+		messages:        100,
+		maxAckPending:   20000,
+		maxRequestBatch: 100,
+		ackWait:         time.Minute,
+		startingOrderID: 400000,
+	}
+
+	_, _, s := initJetStream(t, cfg)
+
+	type (
+		P = map[string]any
+		K = string
+	)
+
+	options := DefaultOptions[P, K]()
+	options.NATSStreamName = streamName
+	options.NATSConsumerName = consumerName
+	options.MaxAge = 2 * time.Second
+	options.IdleFlush = true
+	options.Suffix = "json"
+	options.MessageDecoder = JSONDecoder[P](StaticKey("all"))
+	options.WriterFactory = func() FormattedDataWriter[P] {
+		return &NewLineDelimitedJSON[P]{}
+	}
+	options.Store = SingleDirStore[K](t.TempDir())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nc := clientConnectToServer(t, s)
+	t.Cleanup(nc.Close)
+
+	capture := options.Build()
+
+	done := make(chan error)
+	go func() {
+		done <- capture.Run(ctx, nc)
+	}()
+
+	// no new messages arrive, so only the wall clock can expire the last block before shutdown
+	assert.Eventually(func() bool {
+		return capture.Stats().Acked == cfg.messages
+	}, 8*time.Second, 100*time.Millisecond)
+
+	cancel()
+	assert.Nil(<-done)
+}
+
+// testAcks records acks and naks instead of publishing them
+type testAcks struct {
+	acks, naks []string
+}
+
+func (a *testAcks) Publish(subj string, data []byte) error {
+	if strings.HasPrefix(string(data), "-NAK") {
+		a.naks = append(a.naks, subj)
+	} else {
+		a.acks = append(a.acks, subj)
+	}
+	return nil
+}
+
+func (a *testAcks) Flush() error {
+	return nil
+}
+
+type handlePayload = map[string]any
+
+// newHandleTestCapture returns a capture for tests that call `Capture.handle` directly, which don't need a server.
+// Messages are decoded as JSON and keyed by their second subject token, and blocks are NDJSON stored in a directory
+// per key below root.
+func newHandleTestCapture(t *testing.T, tweak func(options *Options[handlePayload, string])) (c *Capture[handlePayload, string], root string) {
+	root = t.TempDir()
+
+	options := DefaultOptions[handlePayload, string]()
+	options.NATSStreamName = streamName
+	options.NATSConsumerName = consumerName
+	options.MaxAge = time.Minute
+	options.Suffix = "json"
+	options.MessageDecoder = JSONDecoder[handlePayload](SubjectTokenKey(1))
+	options.WriterFactory = func() FormattedDataWriter[handlePayload] {
+		return &NewLineDelimitedJSON[handlePayload]{}
+	}
+	options.Store = &LocalFSStore[string]{
+		Resolver: func(dk string) (string, error) { return filepath.Join(root, dk), nil },
+	}
+
+	if tweak != nil {
+		tweak(options)
+	}
+
+	require.Nil(t, options.Validate())
+
+	c = options.Build()
+	c.acker = &testAcks{}
+
+	return c, root
+}
+
+// testMsg returns a message with the stream sequence seq, as if it was fetched from the stream with the timestamp ts
+func testMsg(subject string, ts time.Time, seq uint64, data string) (*nats.Msg, *nats.MsgMetadata) {
+	return &nats.Msg{
+		Subject: subject,
+		Reply:   fmt.Sprintf("ack.%d", seq),
+		Header:  nats.Header{},
+		Data:    []byte(fmt.Sprintf(`{"seq":%d,"data":%q}`, seq, data)),
+	}, &nats.MsgMetadata{
+		Timestamp: ts,
+		Sequence:  nats.SequencePair{Stream: seq, Consumer: seq},
+	}
+}
+
+// handleAt handles a message for the key "all"
+func handleAt(c *Capture[handlePayload, string], ts time.Time, seq uint64) {
+	c.handle(testMsg("orders.all", ts, seq, _EMPTY_))
+}
+
+// blockRows returns the number of rows of a stored NDJSON block
+func blockRows(t *testing.T, b StoredBlock) int {
+	return strings.Count(readBlockFile(t, b.Path, nil), "\n")
+}
+
+func TestLatePolicy(t *testing.T) {
+	t0 := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+
+	// partition -> rows of each block in it
+	tests := []struct {
+		policy LatePolicy
+		blocks map[string][]int
+	}{
+		{LateNewBlock, map[string][]int{"2024/01/02/03/00": {1, 1}, "2024/01/02/03/01": {1}}},
+		{LateSeparate, map[string][]int{"2024/01/02/03/00": {1}, "2024/01/02/03/01": {1, 1}}},
+		{LateAppend, map[string][]int{"2024/01/02/03/00": {1}, "2024/01/02/03/01": {2}}},
+		{LateRewrite, map[string][]int{"2024/01/02/03/00": {2}, "2024/01/02/03/01": {1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			assert := require.New(t)
+
+			catalog, err := OpenBoltCatalog(filepath.Join(t.TempDir(), "catalog.db"))
+			assert.Nil(err)
+			t.Cleanup(func() { _ = catalog.Close() })
+
+			c, root := newHandleTestCapture(t, func(options *Options[handlePayload, string]) {
+				options.LatePolicy = tt.policy
+				options.Compression = GZip
+				options.Catalog = catalog
+			})
+
+			ctx := context.Background()
+
+			handle := func(ts time.Time, seq uint64) {
+				handleAt(c, ts, seq)
+				c.sweepBlocks(ctx, false)
+			}
+
+			handle(t0.Add(10*time.Second), 1)
+			handle(t0.Add(70*time.Second), 2) // stores the first interval
+			handle(t0.Add(30*time.Second), 3) // late
+
+			c.sweepBlocks(ctx, true)
+
+			assert.Equal(1, c.Stats().Late)
+			assert.Equal(3, c.Stats().Acked)
+
+			stored, err := ListBlocks(root, time.UTC)
+			assert.Nil(err)
+
+			var (
+				blocks    = map[string][]int{}
+				lateFiles int
+			)
+
+			for _, b := range stored {
+				p := b.Partition.Format("2006/01/02/15/04")
+				blocks[p] = append(blocks[p], blockRows(t, b))
+				if strings.HasPrefix(filepath.Base(b.Path), "late-") {
+					lateFiles++
+				}
+			}
+
+			for _, rows := range blocks {
+				sort.Ints(rows)
+			}
+
+			assert.Equal(tt.blocks, blocks)
+
+			if tt.policy == LateSeparate {
+				assert.Equal(1, lateFiles)
+			} else {
+				assert.Zero(lateFiles)
+			}
+
+			// whichever block the late message ended up in, it's found by the time it was sent at
+			entries, err := catalog.Query(ctx, CatalogQuery{From: t0.Add(30 * time.Second), To: t0.Add(31 * time.Second)})
+			assert.Nil(err)
+			assert.True(slices.ContainsFunc(entries, func(e CatalogEntry) bool {
+				return e.FirstSeq <= 3 && e.LastSeq >= 3
+			}), entries)
+		})
+	}
+}
+
+func TestLateRewriteMissing(t *testing.T) {
+	assert := require.New(t)
+
+	c, root := newHandleTestCapture(t, func(options *Options[handlePayload, string]) {
+		options.LatePolicy = LateRewrite
+	})
+
+	ctx := context.Background()
+	t0 := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+
+	handleAt(c, t0.Add(10*time.Second), 1)
+	handleAt(c, t0.Add(70*time.Second), 2)
+	c.sweepBlocks(ctx, false) // stores the first interval
+
+	stored, err := ListBlocks(root, time.UTC)
+	assert.Nil(err)
+	assert.Len(stored, 1)
+
+	// the stored block gets pruned before the late message arrives
+	assert.Nil(os.Remove(stored[0].Path))
+
+	handleAt(c, t0.Add(30*time.Second), 3) // late
+	c.sweepBlocks(ctx, true)
+
+	assert.Equal(1, c.Stats().Late)
+	assert.Equal(3, c.Stats().Acked)
+
+	stored, err = ListBlocks(root, time.UTC)
+	assert.Nil(err)
+	assert.Len(stored, 2)
+
+	rows := map[string]int{}
+	for _, b := range stored {
+		rows[b.Partition.Format("2006/01/02/15/04")] = blockRows(t, b)
+	}
+
+	assert.Equal(map[string]int{"2024/01/02/03/00": 1, "2024/01/02/03/01": 1}, rows)
+}
+
+func TestLateStoreFailure(t *testing.T) {
+	assert := require.New(t)
+
+	c, root := newHandleTestCapture(t, func(options *Options[handlePayload, string]) {
+		options.LatePolicy = LateSeparate
+
+		store, failed := options.Store, false
+		options.Store = testStore[string](func(ctx context.Context, block io.Reader, dk string, dir, fileName string) (string, int64, time.Duration, error) {
+			if !failed {
+				failed = true
+				return _EMPTY_, 0, 0, errors.New("nope")
+			}
+			return store.Write(ctx, block, dk, dir, fileName)
+		})
+	})
+
+	ctx := context.Background()
+	t0 := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+
+	handleAt(c, t0.Add(10*time.Second), 1)
+	handleAt(c, t0.Add(70*time.Second), 2)
+	c.sweepBlocks(ctx, false) // fails to store the first interval
+
+	assert.Equal(0, c.Stats().Acked)
+
+	// the unacked message is redelivered, and still belongs to its own interval
+	handleAt(c, t0.Add(10*time.Second), 1)
+	c.sweepBlocks(ctx, true)
+
+	assert.Zero(c.Stats().Late)
+	assert.Equal(2, c.Stats().Acked)
+
+	stored, err := ListBlocks(root, time.UTC)
+	assert.Nil(err)
+
+	rows := map[string]int{}
+	for _, b := range stored {
+		assert.True(strings.HasPrefix(filepath.Base(b.Path), "backup-"), b.Path)
+		rows[b.Partition.Format("2006/01/02/15/04")] += blockRows(t, b)
+	}
+
+	assert.Equal(map[string]int{"2024/01/02/03/00": 1, "2024/01/02/03/01": 1}, rows)
+}
+
+func TestLateCatchUp(t *testing.T) {
+	assert := require.New(t)
+
+	// catching up on a backlog, IdleFlush stores blocks of past intervals on every sweep, and MaxMessages stores them
+	// early. neither makes the remaining messages of the interval late.
+	c, root := newHandleTestCapture(t, func(options *Options[handlePayload, string]) {
+		options.IdleFlush = true
+		options.MaxMessages = 2
+		options.LatePolicy = LateSeparate
+	})
+
+	ctx := context.Background()
+	t0 := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+
+	for seq := uint64(1); seq <= 6; seq++ {
+		handleAt(c, t0.Add(time.Duration(seq)*20*time.Second), seq)
+		c.sweepBlocks(ctx, false)
+	}
+
+	assert.Zero(c.Stats().Late)
+
+	// the stream moved past the first interval
+	handleAt(c, t0.Add(30*time.Second), 7)
+	c.sweepBlocks(ctx, true)
+
+	assert.Equal(1, c.Stats().Late)
+	assert.Equal(7, c.Stats().Acked)
+
+	stored, err := ListBlocks(root, time.UTC)
+	assert.Nil(err)
+
+	var late []string
+	for _, b := range stored {
+		if strings.HasPrefix(filepath.Base(b.Path), "late-") {
+			late = append(late, b.Partition.Format("15:04"))
+		}
+	}
+	assert.Equal([]string{"03:02"}, late)
+}
+
+func TestCaptureTimeExtractor(t *testing.T) {
+	streamTime := time.Date(2024, 1, 2, 10, 30, 0, 0, time.UTC)
+
+	eventTimes := []string{
+		"2024-01-02T08:15:00Z",
+		"",                     // missing
+		"2023-12-30T10:30:00Z", // too far in the past
+		"2024-01-02T10:40:00Z", // too far in the future
+	}
+
+	// partition -> rows
+	tests := []struct {
+		fallback TimeFallback
+		blocks   map[string]int
+		acked    int
+		failed   int
+	}{
+		{TimeFallbackStream, map[string]int{"08:00": 1, "10:00": 3}, 4, 0},
+		{TimeFallbackDrop, map[string]int{"08:00": 1}, 4, 0},
+		{TimeFallbackFail, map[string]int{"08:00": 1}, 1, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.fallback), func(t *testing.T) {
+			assert := require.New(t)
+
+			c, root := newHandleTestCapture(t, func(options *Options[handlePayload, string]) {
+				options.MaxAge = time.Hour
+				options.TimeExtractor = HeaderTime[handlePayload]("Event-Time", _EMPTY_)
+				options.TimeFallback = tt.fallback
+				options.MaxPastTime = 24 * time.Hour
+				options.MaxFutureTime = 5 * time.Minute
+			})
+
+			for i, et := range eventTimes {
+				m, md := testMsg("devices.d1", streamTime, uint64(i+1), _EMPTY_)
+				if et != _EMPTY_ {
+					m.Header.Set("Event-Time", et)
+				}
+
+				c.handle(m, md)
+			}
+
+			c.sweepBlocks(context.Background(), true)
+
+			stats := c.Stats()
+			assert.Equal(3, stats.TimeFallbacks)
+			assert.Equal(tt.acked, stats.Acked)
+			assert.Equal(tt.failed, stats.Failed)
+
+			stored, err := ListBlocks(root, time.UTC)
+			assert.Nil(err)
+
+			blocks := map[string]int{}
+			for _, b := range stored {
+				blocks[b.Partition.Format("15:04")] += blockRows(t, b)
+			}
+
+			assert.Equal(tt.blocks, blocks)
+		})
+	}
+}
+
+func TestCaptureTimeExtractorClock(t *testing.T) {
+	assert := require.New(t)
+
+	streamTime := time.Date(2024, 1, 2, 10, 30, 0, 0, time.UTC)
+
+	c, root := newHandleTestCapture(t, func(options *Options[handlePayload, string]) {
+		options.MaxAge = time.Hour
+		options.TimeExtractor = HeaderTime[handlePayload]("Event-Time", _EMPTY_)
+	})
+
+	ctx := context.Background()
+
+	for i, et := range []string{
+		"2024-01-02T10:25:00Z",
+		"2099-01-01T00:00:00Z",      // bogus, but MaxFutureTime is unlimited
+		"2024-01-02T10:20:00+02:00", // 08:20 UTC
+		"2024-01-02T10:28:00Z",
+	} {
+		m, md := testMsg("devices.d1", streamTime, uint64(i+1), _EMPTY_)
+		m.Header.Set("Event-Time", et)
+
+		c.handle(m, md)
+		c.sweepBlocks(ctx, false)
+	}
+
+	// the clock stays at the stream time, so the 10:00 interval wasn't flushed. neither was the 08:00 block, which was
+	// only opened at 10:30
+	stored, err := ListBlocks(root, time.UTC)
+	assert.Nil(err)
+	assert.Empty(stored)
+	assert.Zero(c.Stats().Late)
+
+	c.sweepBlocks(ctx, true)
+
+	stored, err = ListBlocks(root, time.UTC)
+	assert.Nil(err)
+
+	blocks := map[string]int{}
+	for _, b := range stored {
+		blocks[b.Partition.Format("2006/01/02/15")] += blockRows(t, b)
+	}
+
+	assert.Equal(map[string]int{"2024/01/02/08": 1, "2024/01/02/10": 2, "2099/01/01/00": 1}, blocks)
+	assert.Len(stored, 3)
+}
+
+func TestCaptureTimeExtractorBackfill(t *testing.T) {
+	assert := require.New(t)
+
+	c, root := newHandleTestCapture(t, func(options *Options[handlePayload, string]) {
+		options.MaxAge = time.Hour
+		options.TimeExtractor = HeaderTime[handlePayload]("Event-Time", _EMPTY_)
+	})
+
+	ctx := context.Background()
+	streamTime := time.Date(2024, 1, 2, 10, 30, 0, 0, time.UTC)
+	backfill := time.Date(2024, 1, 2, 8, 0, 0, 0, time.UTC)
+
+	// one device publishes live, while another one publishes what it buffered offline since 08:00
+	for i := 0; i < 10; i++ {
+		ts := streamTime.Add(time.Duration(i) * time.Minute)
+
+		live, md := testMsg("devices.live", ts, uint64(2*i+1), _EMPTY_)
+		live.Header.Set("Event-Time", ts.Format(time.RFC3339))
+		c.handle(live, md)
+
+		old, md := testMsg("devices.old", ts.Add(time.Second), uint64(2*i+2), _EMPTY_)
+		old.Header.Set("Event-Time", backfill.Add(time.Duration(i)*10*time.Minute).Format(time.RFC3339))
+		c.handle(old, md)
+
+		c.sweepBlocks(ctx, false)
+	}
+
+	assert.Zero(c.Stats().Late)
+
+	// more than an hour of stream time after they were opened, the backfilled blocks are due
+	live, md := testMsg("devices.live", streamTime.Add(2*time.Hour), 21, _EMPTY_)
+	live.Header.Set("Event-Time", md.Timestamp.Format(time.RFC3339))
+	c.handle(live, md)
+	c.sweepBlocks(ctx, false)
+
+	stored, err := ListBlocks(root, time.UTC)
+	assert.Nil(err)
+
+	blocks := map[string]int{}
+	for _, b := range stored {
+		blocks[b.DestDir+" "+b.Partition.Format("15:04")] += blockRows(t, b)
+	}
+
+	assert.Equal(map[string]int{"live 10:00": 10, "old 08:00": 6, "old 09:00": 4}, blocks)
+	assert.Len(stored, 3)
+	assert.Zero(c.Stats().Late)
+}
+
+func TestCaptureExactlyOnce(t *testing.T) {
+	assert := require.New(t)
+
+	root := t.TempDir()
+	t0 := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+
+	newCapture := func() *Capture[handlePayload, string] {
+		c, _ := newHandleTestCapture(t, func(options *Options[handlePayload, string]) {
+			options.ExactlyOnce = true
+			options.Store = &LocalFSStore[string]{
+				Resolver:       func(string) (string, error) { return root, nil },
+				NoClobber:      true,
+				ReplaceChanged: true,
+			}
+		})
+		return c
+	}
+
+	handle := func(c *Capture[handlePayload, string], seq uint64) {
+		handleAt(c, t0.Add(time.Duration(seq)*time.Second), seq)
+	}
+
+	listBlocks := func() []StoredBlock {
+		blocks, err := ListBlocks(root, time.UTC)
+		assert.Nil(err)
+		return blocks
+	}
+
+	// the second delivery of 2 is dropped
+	c := newCapture()
+	for _, seq := range []uint64{2, 3, 2, 4} {
+		handle(c, seq)
+	}
+	c.sweepBlocks(context.Background(), true)
+
+	assert.Equal(1, c.Stats().Duplicates)
+	assert.Equal(4, c.Stats().Acked)
+
+	blocks := listBlocks()
+	assert.Len(blocks, 1)
+	assert.Equal(3, blockRows(t, blocks[0]))
+
+	stat := func(b StoredBlock) os.FileInfo {
+		fi, err := os.Stat(b.Path)
+		assert.Nil(err)
+		return fi
+	}
+
+	stored := stat(blocks[0])
+
+	// the acks got lost, so everything is redelivered to the next run which writes the same block again and skips it
+	c = newCapture()
+	for _, seq := range []uint64{2, 3, 4} {
+		handle(c, seq)
+	}
+	c.sweepBlocks(context.Background(), true)
+
+	assert.Zero(c.Stats().Duplicates)
+	assert.Equal(3, c.Stats().Acked)
+	assert.Equal(blocks, listBlocks())
+	assert.True(os.SameFile(stored, stat(blocks[0])))
+
+	// redelivered out of order, the rows of the block differ, so it replaces the stored block with the same messages
+	c = newCapture()
+	for _, seq := range []uint64{3, 2, 4} {
+		handle(c, seq)
+	}
+	c.sweepBlocks(context.Background(), true)
+
+	assert.Equal(3, c.Stats().Acked)
+
+	blocks = listBlocks()
+	assert.Len(blocks, 1)
+	assert.Equal(3, blockRows(t, blocks[0]))
+	assert.False(os.SameFile(stored, stat(blocks[0])))
+
+	// the next run got more messages before its block was stored, so the stored block is replaced rather than skipped
+	c = newCapture()
+	for _, seq := range []uint64{2, 3, 4, 5} {
+		handle(c, seq)
+	}
+	c.sweepBlocks(context.Background(), true)
+
+	assert.Equal(4, c.Stats().Acked)
+
+	blocks = listBlocks()
+	assert.Len(blocks, 1)
+	assert.Equal(4, blockRows(t, blocks[0]))
+
+	// a block with a different first sequence is a different block
+	c = newCapture()
+	handle(c, 6)
+	c.sweepBlocks(context.Background(), true)
+
+	assert.Len(listBlocks(), 2)
+}
+
+func TestCaptureExactlyOnceMultiStore(t *testing.T) {
+	assert := require.New(t)
+
+	dir1, dir2 := t.TempDir(), t.TempDir()
+	t0 := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+
+	noClobber := func(root string) BlockStore[string] {
+		return &LocalFSStore[string]{
+			Resolver:  func(string) (string, error) { return root, nil },
+			NoClobber: true,
+		}
+	}
+
+	run := func(store BlockStore[string]) *Capture[handlePayload, string] {
+		c, _ := newHandleTestCapture(t, func(options *Options[handlePayload, string]) {
+			options.ExactlyOnce = true
+			options.Store = store
+		})
+
+		for seq := uint64(1); seq <= 3; seq++ {
+			handleAt(c, t0.Add(time.Duration(seq)*time.Second), seq)
+		}
+		c.sweepBlocks(context.Background(), true)
+
+		return c
+	}
+
+	listBlocks := func(root string) []StoredBlock {
+		blocks, err := ListBlocks(root, time.UTC)
+		assert.Nil(err)
+		return blocks
+	}
+
+	// the first run only got as far as the local store
+	run(noClobber(dir1))
+
+	// the local store already has the block, which doesn't keep the redelivered block from the second store
+	c := run(NewMultiStore(MultiStoreAll, noClobber(dir1), noClobber(dir2)))
+	assert.Equal(3, c.Stats().Acked)
+	assert.Len(listBlocks(dir1), 1)
+	assert.Len(listBlocks(dir2), 1)
+
+	// once both stores have the block, it is skipped
+	c = run(NewMultiStore(MultiStoreAll, noClobber(dir1), noClobber(dir2)))
+	assert.Equal(3, c.Stats().Acked)
+
+	assert.True(onlyErrExist(errors.Join(os.ErrExist, Permanent(&os.PathError{Op: "link", Err: os.ErrExist}))))
+	assert.False(onlyErrExist(errors.Join(os.ErrExist, errors.New("nope"))))
+	assert.False(onlyErrExist(errors.New("nope")))
+}
+
+func TestMemoryLimit(t *testing.T) {
+	t0 := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+
+	for _, policy := range []MemoryPolicy{MemorySpill, MemoryFinalize} {
+		t.Run(string(policy), func(t *testing.T) {
+			assert := require.New(t)
+
+			c, root := newHandleTestCapture(t, func(options *Options[handlePayload, string]) {
+				options.BufferToDisk = false
+				options.TempDir = t.TempDir()
+				options.MaxMemoryBytes = 5000
+				options.MemoryPolicy = policy
+			})
+
+			data := strings.Repeat("x", 90)
+
+			// "a" is the oldest block, "b" the largest
+			for i, dk := range []string{"a", "b", "b", "b", "c"} {
+				minute := map[string]int{"a": 0, "b": 1, "c": 2}[dk]
+
+				for j := 0; j < 10; j++ {
+					c.handle(testMsg("devices."+dk, t0.Add(time.Duration(minute)*time.Minute), uint64(i*10+j+1), data))
+				}
+			}
+
+			assert.Greater(c.Stats().MemoryBytes, c.opts.MaxMemoryBytes)
+
+			batch := c.fetchBatch(100)
+			assert.Equal(1, batch)
+
+			c.limitMemory(context.Background())
+
+			stats := c.Stats()
+			assert.Equal(1, stats.MemoryFlushes)
+			assert.LessOrEqual(stats.MemoryBytes, c.opts.MaxMemoryBytes)
+			assert.Greater(c.fetchBatch(100), batch)
+
+			if policy == MemorySpill {
+				assert.NotNil(c.blocks["b"][0].base.(*memoryBuffer).disk)
+				assert.Equal(c.blocks["b"][0].spoolSize(), c.spool.Load())
+				assert.Zero(stats.Acked)
+			} else {
+				assert.Empty(c.blocks["a"])
+				assert.Equal(10, stats.Acked)
+			}
+
+			c.sweepBlocks(context.Background(), true)
+			assert.Zero(c.Stats().MemoryBytes)
+
+			stored, err := ListBlocks(root, time.UTC)
+			assert.Nil(err)
+
+			rows := map[string]int{}
+			for _, b := range stored {
+				rows[b.DestDir] += blockRows(t, b)
+			}
+			assert.Equal(map[string]int{"a": 10, "b": 30, "c": 10}, rows)
+
+			// spilled buffers are removed along with their block
+			temp, err := os.ReadDir(c.opts.TempDir)
+			assert.Nil(err)
+			assert.Empty(temp)
+		})
+	}
+}
+
+func TestSpoolLimit(t *testing.T) {
+	assert := require.New(t)
+
+	c, root := newHandleTestCapture(t, func(options *Options[handlePayload, string]) {
+		options.BufferToDisk = true
+		options.TempDir = t.TempDir()
+		options.MaxSpoolBytes = 3000
+	})
+
+	data := strings.Repeat("x", 90)
+	t0 := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+
+	// "b" has the largest temp file
+	for i, dk := range []string{"a", "b", "b", "c"} {
+		for j := 0; j < 10; j++ {
+			seq := uint64(i*10 + j + 1)
+			c.handle(testMsg("devices."+dk, t0.Add(time.Duration(seq)*time.Second), seq, data))
+		}
+	}
+
+	assert.Greater(c.Stats().SpoolBytes, c.opts.MaxSpoolBytes)
+
+	ctx := context.Background()
+
+	assert.False(c.limitSpool(ctx))
+
+	stats := c.Stats()
+	assert.Equal(1, stats.SpoolFlushes)
+	assert.Equal(20, stats.Acked)
+	assert.LessOrEqual(stats.SpoolBytes, c.opts.MaxSpoolBytes)
+	assert.Empty(c.blocks["b"])
+	assert.Nil(c.Health())
+
+	// no file system has this much space, so all blocks are stored and fetching pauses
+	c.opts.MinFreeSpoolBytes = math.MaxUint64
+
+	assert.True(c.limitSpool(ctx))
+
+	stats = c.Stats()
+	assert.Equal(3, stats.SpoolFlushes)
+	assert.Equal(40, stats.Acked)
+	assert.Zero(stats.SpoolBytes)
+	assert.True(stats.FetchPaused)
+	assert.ErrorContains(c.Health(), "MinFreeSpoolBytes")
+
+	c.opts.MinFreeSpoolBytes = 0
+
+	assert.False(c.limitSpool(ctx))
+	assert.False(c.Stats().FetchPaused)
+	assert.Nil(c.Health())
+
+	stored, err := ListBlocks(root, time.UTC)
+	assert.Nil(err)
+
+	rows := map[string]int{}
+	for _, b := range stored {
+		rows[b.DestDir] += blockRows(t, b)
+	}
+	assert.Equal(map[string]int{"a": 10, "b": 20, "c": 10}, rows)
+
+	// without temp files there is nothing to store early, so MinFreeSpoolBytes doesn't pause fetching
+	c.opts.BufferToDisk = false
+	c.opts.MinFreeSpoolBytes = math.MaxUint64
+
+	handleAt(c, t0, 41)
+
+	assert.False(c.limitSpool(ctx))
+	assert.False(c.Stats().FetchPaused)
+}
+
+func TestSpoolLimitBatch(t *testing.T) {
+	assert := require.New(t)
+
+	c, _ := newHandleTestCapture(t, func(options *Options[handlePayload, string]) {
+		options.BufferToDisk = true
+		options.TempDir = t.TempDir()
+		options.MinFreeSpoolBytes = math.MaxUint64
+	})
+
+	data := strings.Repeat("x", spoolCheckBytes/2)
+	t0 := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+
+	var messages []*nats.Msg
+
+	for seq := uint64(1); seq <= 6; seq++ {
+		ts := t0.Add(time.Duration(seq) * time.Second)
+		m, _ := testMsg("orders.all", ts, seq, data)
+		m.Sub = &nats.Subscription{}
+		m.Reply = fmt.Sprintf("$JS.ACK.orders.capture.1.%d.%d.%d.0", seq, seq, ts.UnixNano())
+		messages = append(messages, m)
+	}
+
+	// the spool is only checked once a megabyte was handled, and stays full, so the rest of the batch is nacked to be
+	// redelivered once fetching resumes, rather than once the ack wait expires
+	c.handleBatch(context.Background(), messages)
+
+	stats := c.Stats()
+	assert.Equal(1, stats.SpoolFlushes)
+	assert.Equal(2, stats.Acked)
+	assert.Equal(4, stats.Nacked)
+	assert.True(stats.FetchPaused)
+
+	acks := c.acker.(*testAcks)
+	assert.Equal([]string{messages[0].Reply, messages[1].Reply}, acks.acks)
+
+	var naks []string
+	for _, m := range messages[2:] {
+		naks = append(naks, m.Reply)
+	}
+	assert.Equal(naks, acks.naks)
 }
@@ -0,0 +1,130 @@
+package jetcapture
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Catalog records where blocks were stored, so restores can find the blocks for a time window or sequence range
+// without listing the store. Set `Options.Catalog` to add each block once it was successfully stored, and
+// `PruneOptions.Catalog` or `CompactOptions.Catalog` to keep it up to date when blocks are removed or merged.
+type Catalog interface {
+	// Add adds an entry, replacing an existing entry with the same id
+	Add(ctx context.Context, entry CatalogEntry) error
+	// Remove removes the entry of a block that no longer exists (see BlockIDOf). Unknown ids are ignored.
+	Remove(ctx context.Context, id string) error
+	// Query returns the matching entries sorted by destination key, start and sequence
+	Query(ctx context.Context, query CatalogQuery) ([]CatalogEntry, error)
+}
+
+// CatalogEntry describes a stored block
+type CatalogEntry struct {
+	DestKey       string    `json:"dest_key"` // formatted using `fmt.Sprint`, so keys can implement `fmt.Stringer`
+	Path          string    `json:"path"`     // as returned by `BlockStore.Write`
+	ID            string    `json:"id"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	OldestMessage time.Time `json:"oldest_message"` // late blocks hold messages from before Start
+	NewestMessage time.Time `json:"newest_message"`
+	FirstSeq      uint64    `json:"first_seq"`
+	LastSeq       uint64    `json:"last_seq"`
+	MessageCount  int       `json:"message_count"`
+	RowCount      int       `json:"row_count"`
+	Size          int64     `json:"size"`
+}
+
+func NewCatalogEntry(destKey, path string, size int64, m BlockManifest) CatalogEntry {
+	return CatalogEntry{
+		DestKey:       destKey,
+		Path:          path,
+		ID:            m.ID,
+		Start:         m.Start,
+		End:           m.End,
+		OldestMessage: m.OldestMessage,
+		NewestMessage: m.NewestMessage,
+		FirstSeq:      m.FirstSeq,
+		LastSeq:       m.LastSeq,
+		MessageCount:  m.MessageCount,
+		RowCount:      m.RowCount,
+		Size:          size,
+	}
+}
+
+// bounds returns the time range covered by the block window and its messages
+func (e CatalogEntry) bounds() (from, to time.Time) {
+	from, to = e.Start, e.End
+
+	if !e.OldestMessage.IsZero() && e.OldestMessage.Before(from) {
+		from = e.OldestMessage
+	}
+
+	// End is exclusive, while the newest message is part of the block
+	if newest := e.NewestMessage.Add(time.Nanosecond); !e.NewestMessage.IsZero() && newest.After(to) {
+		to = newest
+	}
+
+	return from, to
+}
+
+// manifest returns what the entry knows about its block
+func (e CatalogEntry) manifest() BlockManifest {
+	return BlockManifest{
+		ID:            e.ID,
+		Start:         e.Start,
+		End:           e.End,
+		OldestMessage: e.OldestMessage,
+		NewestMessage: e.NewestMessage,
+		MessageCount:  e.MessageCount,
+		RowCount:      e.RowCount,
+		FirstSeq:      e.FirstSeq,
+		LastSeq:       e.LastSeq,
+	}
+}
+
+// CatalogQuery selects the blocks overlapping a time window and/or sequence range. Zero values match everything.
+type CatalogQuery struct {
+	DestKeys       []string  // only return blocks of these destination keys. defaults to all
+	From, To       time.Time // blocks whose window or messages overlap [From, To)
+	MinSeq, MaxSeq uint64    // blocks overlapping [MinSeq, MaxSeq]
+}
+
+func (q CatalogQuery) Matches(e CatalogEntry) bool {
+	if len(q.DestKeys) > 0 && !slices.Contains(q.DestKeys, e.DestKey) {
+		return false
+	}
+
+	from, to := e.bounds()
+
+	if !q.From.IsZero() && !to.After(q.From) {
+		return false
+	}
+
+	if !q.To.IsZero() && !from.Before(q.To) {
+		return false
+	}
+
+	if q.MinSeq > 0 && e.LastSeq < q.MinSeq {
+		return false
+	}
+
+	if q.MaxSeq > 0 && e.FirstSeq > q.MaxSeq {
+		return false
+	}
+
+	return true
+}
+
+func sortCatalogEntries(entries []CatalogEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.DestKey != b.DestKey {
+			return a.DestKey < b.DestKey
+		}
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Before(b.Start)
+		}
+		return a.FirstSeq < b.FirstSeq
+	})
+}
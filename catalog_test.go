@@ -0,0 +1,208 @@
+package jetcapture
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testCatalog(t *testing.T, catalog Catalog) {
+	assert := require.New(t)
+
+	ctx := context.Background()
+
+	start := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+
+	// 15 minute blocks for two destinations, with interleaved sequences
+	for i := 0; i < 8; i++ {
+		for j, dk := range []string{"42", ""} {
+			seq := uint64(i*20 + j*10 + 1)
+			assert.Nil(catalog.Add(ctx, CatalogEntry{
+				DestKey:  dk,
+				Path:     fmt.Sprintf("%s/block-%d", dk, i),
+				ID:       fmt.Sprintf("%02d-%d", i, j),
+				Start:    start.Add(time.Duration(i) * 15 * time.Minute),
+				End:      start.Add(time.Duration(i+1) * 15 * time.Minute),
+				FirstSeq: seq,
+				LastSeq:  seq + 9,
+				RowCount: 10,
+			}))
+		}
+	}
+
+	paths := func(q CatalogQuery) (p []string) {
+		entries, err := catalog.Query(ctx, q)
+		assert.Nil(err)
+		for _, e := range entries {
+			p = append(p, e.Path)
+		}
+		return
+	}
+
+	assert.Len(paths(CatalogQuery{}), 16)
+
+	// everything for store 42 between 10:00 and 11:30
+	assert.Equal(
+		[]string{"42/block-0", "42/block-1", "42/block-2", "42/block-3", "42/block-4", "42/block-5"},
+		paths(CatalogQuery{DestKeys: []string{"42"}, From: start, To: start.Add(90 * time.Minute)}),
+	)
+
+	// windows that don't line up with the blocks still return every overlapping block
+	assert.Equal(
+		[]string{"/block-1", "/block-2", "42/block-1", "42/block-2"},
+		paths(CatalogQuery{From: start.Add(20 * time.Minute), To: start.Add(31 * time.Minute)}),
+	)
+
+	assert.Equal(
+		[]string{"/block-0", "42/block-1"},
+		paths(CatalogQuery{MinSeq: 15, MaxSeq: 25}),
+	)
+
+	assert.Empty(paths(CatalogQuery{DestKeys: []string{"nope"}}))
+
+	// a late block of the last interval holding messages from the first
+	late := CatalogEntry{
+		DestKey:       "42",
+		Path:          "42/late",
+		ID:            "late",
+		Start:         start.Add(105 * time.Minute),
+		End:           start.Add(120 * time.Minute),
+		OldestMessage: start.Add(5 * time.Minute),
+		NewestMessage: start.Add(110 * time.Minute),
+		FirstSeq:      200,
+		LastSeq:       201,
+	}
+
+	assert.Nil(catalog.Add(ctx, late))
+
+	assert.Equal(
+		[]string{"42/block-0", "42/late"},
+		paths(CatalogQuery{DestKeys: []string{"42"}, From: start, To: start.Add(10 * time.Minute)}),
+	)
+
+	// rewritten with an even older message, which replaces the entry
+	late.OldestMessage = start.Add(-time.Hour)
+	late.LastSeq = 202
+	assert.Nil(catalog.Add(ctx, late))
+
+	assert.Len(paths(CatalogQuery{}), 17)
+	assert.Equal([]string{"42/late"}, paths(CatalogQuery{To: start}))
+
+	// removed blocks (e.g. pruned or compacted), and unknown ids
+	for _, id := range []string{"late", "00-0", "nope"} {
+		assert.Nil(catalog.Remove(ctx, id))
+	}
+
+	assert.Len(paths(CatalogQuery{}), 15)
+	assert.Empty(paths(CatalogQuery{To: start.Add(15 * time.Minute), DestKeys: []string{"42"}}))
+}
+
+func TestBoltCatalog(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "catalog.db")
+
+	catalog, err := OpenBoltCatalog(p)
+	require.Nil(t, err)
+
+	testCatalog(t, catalog)
+
+	require.Nil(t, catalog.Close())
+
+	// entries survive reopening
+	catalog, err = OpenBoltCatalog(p)
+	require.Nil(t, err)
+	t.Cleanup(func() { _ = catalog.Close() })
+
+	entries, err := catalog.Query(context.Background(), CatalogQuery{DestKeys: []string{""}})
+	require.Nil(t, err)
+	require.Len(t, entries, 8)
+}
+
+func TestKVCatalog(t *testing.T) {
+	s := runBasicJetStreamServer(t)
+	t.Cleanup(s.Shutdown)
+
+	nc := clientConnectToServer(t, s)
+	t.Cleanup(nc.Close)
+
+	js, err := nc.JetStream()
+	require.Nil(t, err)
+
+	catalog, err := NewKVCatalog(js, "catalog")
+	require.Nil(t, err)
+
+	testCatalog(t, catalog)
+}
+
+func TestCaptureCatalog(t *testing.T) {
+	assert := require.New(t)
+
+	cfg := captureTestConfig{
+		messages:        500,
+		maxAckPending:   20000,
+		maxRequestBatch: 100,
+		ackWait:         time.Minute,
+		startingOrderID: 300000,
+	}
+
+	_, _, s := initJetStream(t, cfg)
+
+	type (
+		P = map[string]any
+		K = string
+	)
+
+	catalog, err := OpenBoltCatalog(filepath.Join(t.TempDir(), "catalog.db"))
+	assert.Nil(err)
+	t.Cleanup(func() { _ = catalog.Close() })
+
+	output := t.TempDir()
+
+	options := DefaultOptions[P, K]()
+	options.NATSStreamName = streamName
+	options.NATSConsumerName = consumerName
+	options.MaxAge = 10 * time.Second
+	options.Suffix = "json"
+	options.MessageDecoder = JSONDecoder[P](JSONPointerKey("/customer_name"))
+	options.WriterFactory = func() FormattedDataWriter[P] {
+		return &NewLineDelimitedJSON[P]{}
+	}
+	options.Store = &LocalFSStore[K]{
+		Resolver: func(dk K) (string, error) {
+			return filepath.Join(output, dk), nil
+		},
+	}
+	options.Catalog = catalog
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	nc := clientConnectToServer(t, s)
+	t.Cleanup(nc.Close)
+
+	assert.ErrorIs(options.Build().Run(ctx, nc), context.DeadlineExceeded)
+
+	entries, err := catalog.Query(context.Background(), CatalogQuery{})
+	assert.Nil(err)
+	assert.GreaterOrEqual(len(entries), 26)
+
+	var messages, rows int
+
+	for _, e := range entries {
+		assert.FileExists(e.Path)
+		assert.True(strings.HasPrefix(e.Path, filepath.Join(output, e.DestKey)+"/"))
+		assert.LessOrEqual(e.FirstSeq, e.LastSeq)
+		assert.False(e.OldestMessage.IsZero())
+		assert.False(e.NewestMessage.Before(e.OldestMessage))
+		assert.Equal(10*time.Second, e.End.Sub(e.Start))
+		messages += e.MessageCount
+		rows += e.RowCount
+	}
+
+	assert.Equal(cfg.messages, messages)
+	assert.Equal(cfg.messages, rows)
+}
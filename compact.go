@@ -0,0 +1,493 @@
+package jetcapture
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+const (
+	DefaultCompactPeriod = 24 * time.Hour
+	DefaultCompactPrefix = "compacted"
+)
+
+// CompactOptions configures Compact
+type CompactOptions struct {
+	DestDirs    []string      // only compact these destination directories (relative to the root). defaults to all
+	From, To    time.Time     // only compact blocks with a partition in [From, To). zero values are unbounded
+	Period      time.Duration // merged window, must evenly divide a day. defaults to DefaultCompactPeriod
+	Prefix      string        // file name prefix of merged blocks. defaults to DefaultCompactPrefix
+	CSVNoHeader bool          // CSV blocks don't start with a header row
+	KeyWrapper  KeyWrapper    // decrypts encrypted blocks and encrypts their merged block
+	TempDir     string        // merged blocks are buffered in this directory. defaults to `os.TempDir()`
+	DryRun      bool          // only report what would be merged
+
+	// Catalog optionally replaces the entries of the source blocks with an entry of the merged block, which (along
+	// with its manifest) gets the sequences, message count and message times of the source entries
+	Catalog Catalog
+
+	Location *time.Location   // zone the partition directories were rendered in. defaults to `time.Local`
+	Now      func() time.Time // defaults to `time.Now`
+}
+
+// CompactResult describes a merged block
+type CompactResult struct {
+	Path    string // as returned by `BlockStore.Write`. empty for dry runs
+	Start   time.Time
+	Sources []StoredBlock
+	Rows    int
+	Bytes   int64
+}
+
+type compactGroup struct {
+	destDir     string
+	start       time.Time
+	end         time.Time
+	suffix      string
+	compression Compression
+	encrypted   bool
+}
+
+// Compact merges the blocks of a tree written by LocalFSStore into one block per destination directory and window
+// (e.g. a day). Blocks are only merged with blocks of the same format (NDJSON or CSV), compression and encryption.
+// Merged blocks are written to the store using the destination key returned by destKey, and their row count is
+// verified before the source blocks are removed. Windows that haven't ended yet are left alone.
+func Compact[K DestKey](
+	ctx context.Context,
+	root string,
+	store BlockStore[K],
+	destKey func(destDir string) (K, error),
+	opts CompactOptions,
+) ([]CompactResult, error) {
+	if opts.Period == 0 {
+		opts.Period = DefaultCompactPeriod
+	}
+
+	if opts.Period < time.Minute || (24*time.Hour)%opts.Period != 0 {
+		return nil, fmt.Errorf("compaction period %s must evenly divide a day", opts.Period)
+	}
+
+	// windows follow the wall clock of the partition directories, so days with a DST change are 23 or 25 hours long
+	window, err := FixedWindow(opts.Period, opts.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Prefix == _EMPTY_ {
+		opts.Prefix = DefaultCompactPrefix
+	}
+
+	now := time.Now()
+	if opts.Now != nil {
+		now = opts.Now()
+	}
+
+	blocks, err := ListBlocks(root, opts.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		groups = map[compactGroup][]StoredBlock{}
+		keys   []compactGroup
+	)
+
+	for _, b := range blocks {
+		if len(opts.DestDirs) > 0 && !slices.Contains(opts.DestDirs, b.DestDir) {
+			continue
+		}
+
+		if (!opts.From.IsZero() && b.Partition.Before(opts.From)) || (!opts.To.IsZero() && !b.Partition.Before(opts.To)) {
+			continue
+		}
+
+		g := compactGroup{
+			destDir: b.DestDir,
+			start:   window.Start(b.Partition),
+		}
+
+		g.end = window.End(g.start)
+
+		if g.end.After(now) {
+			continue
+		}
+
+		g.suffix, g.compression, g.encrypted = ParseBlockFileName(filepath.Base(b.Path))
+
+		if _, ok := groups[g]; !ok {
+			keys = append(keys, g)
+		}
+
+		groups[g] = append(groups[g], b)
+	}
+
+	// ListBlocks sorts by destination and partition, so the keys already are in a stable order
+	var results []CompactResult
+
+	for _, g := range keys {
+		sources := groups[g]
+		if len(sources) < 2 {
+			continue
+		}
+
+		if opts.DryRun {
+			result := CompactResult{Start: g.start, Sources: sources}
+			for _, b := range sources {
+				result.Bytes += b.Size
+			}
+			results = append(results, result)
+			continue
+		}
+
+		dk, err := destKey(g.destDir)
+		if err != nil {
+			return results, err
+		}
+
+		result, err := compactBlocks(ctx, store, dk, g, sources, opts)
+		if err != nil {
+			if errors.Is(err, errUnsupportedFormat) {
+				log.Warnf("skipping %d blocks in %s: %s", len(sources), g.destDir, err)
+				continue
+			}
+			return results, err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+var errUnsupportedFormat = errors.New("unsupported block format")
+
+func compactBlocks[K DestKey](
+	ctx context.Context,
+	store BlockStore[K],
+	dk K,
+	g compactGroup,
+	sources []StoredBlock,
+	opts CompactOptions,
+) (CompactResult, error) {
+	result := CompactResult{
+		Start:   g.start,
+		Sources: sources,
+	}
+
+	// fail early for formats that can't be merged
+	if _, err := newBlockMerger(g.suffix, io.Discard, csvNoHeader); err != nil {
+		return result, err
+	}
+
+	tempDir := opts.TempDir
+	if tempDir == _EMPTY_ {
+		tempDir = os.TempDir()
+	}
+
+	base, err := newDiskBuffer(tempDir)
+	if err != nil {
+		return result, err
+	}
+
+	defer func() {
+		_ = base.(*diskBuffer).Close()
+		_ = base.Remove()
+	}()
+
+	var kw KeyWrapper
+	if g.encrypted {
+		if opts.KeyWrapper == nil {
+			return result, errors.New("blocks are encrypted but no key was provided")
+		}
+		kw = opts.KeyWrapper
+	}
+
+	buf, err := wrapBuffer(base, g.compression, kw)
+	if err != nil {
+		return result, err
+	}
+
+	header := csvHeader
+	if opts.CSVNoHeader {
+		header = csvNoHeader
+	}
+
+	merger, _ := newBlockMerger(g.suffix, buf, header)
+
+	for _, b := range sources {
+		n, err := mergeSource(merger, b, opts.KeyWrapper)
+		if err != nil {
+			return result, err
+		}
+		result.Rows += n
+	}
+
+	if err := merger.flush(); err != nil {
+		return result, err
+	}
+
+	if err := buf.DoneWriting(); err != nil {
+		return result, err
+	}
+
+	id := ulid.MustNew(ulid.Timestamp(g.start), ulid.DefaultEntropy()).String()
+
+	fileName := fmt.Sprintf("%s-%s.%s",
+		opts.Prefix,
+		id,
+		blockFileSuffix(g.suffix, g.compression, g.encrypted),
+	)
+
+	// read the merged block back to make sure nothing was lost on the way
+	verify, err := OpenBlock(buf, fileName, kw)
+	if err != nil {
+		return result, err
+	}
+
+	verifier, _ := newBlockMerger(g.suffix, io.Discard, header)
+
+	if rows, err := verifier.merge(verify); err != nil {
+		return result, fmt.Errorf("verifying merged block: %w", err)
+	} else if rows != result.Rows {
+		return result, fmt.Errorf("merged block has %d rows, expected %d", rows, result.Rows)
+	}
+
+	size, err := buf.Seek(0, io.SeekEnd)
+	if err != nil {
+		return result, err
+	}
+
+	if _, err := buf.Seek(0, io.SeekStart); err != nil {
+		return result, err
+	}
+
+	manifest := BlockManifest{
+		ID:          id,
+		Start:       g.start,
+		End:         g.end,
+		Compression: g.compression,
+		Encrypted:   g.encrypted,
+	}
+
+	if opts.Catalog != nil {
+		if manifest, err = catalogManifest(ctx, opts.Catalog, fmt.Sprint(dk), manifest, sources); err != nil {
+			return result, err
+		}
+	}
+
+	manifest.RowCount = result.Rows
+
+	block := &manifestReader{ReadSeeker: buf, manifest: manifest}
+
+	p, n, _, err := store.Write(ctx, block, dk, partitionPath(g.start), fileName)
+	if err != nil {
+		return result, err
+	}
+
+	if n != size {
+		return result, fmt.Errorf("store wrote %d bytes of %d byte merged block %s", n, size, p)
+	}
+
+	result.Path = p
+	result.Bytes = n
+
+	log.Infow("compacted blocks", "path", p, "sources", len(sources), "rows", result.Rows, "size", n)
+
+	if opts.Catalog != nil {
+		// the merged block is stored, so the sources are removed either way. like Capture, catalog errors are only logged
+		if err := opts.Catalog.Add(ctx, NewCatalogEntry(fmt.Sprint(dk), p, n, manifest)); err != nil {
+			log.Errorw("unable to add block to catalog", "path", p, "error", err)
+		}
+
+		for _, b := range sources {
+			id := BlockIDOf(filepath.Base(b.Path))
+			if err := opts.Catalog.Remove(ctx, id); err != nil {
+				log.Errorw("unable to remove block from catalog", "path", b.Path, "id", id, "error", err)
+			}
+		}
+	}
+
+	for _, b := range sources {
+		for _, s := range append(b.Sidecars, b.Path) {
+			if err := os.Remove(s); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return result, err
+			}
+		}
+	}
+
+	_, err = removeEmptyPartitions(sources)
+
+	return result, err
+}
+
+// catalogManifest merges the catalog entries of the source blocks into the manifest of the merged block. sources
+// without an entry (e.g. stored before the catalog was enabled) only contribute their rows.
+func catalogManifest(
+	ctx context.Context,
+	catalog Catalog,
+	destKey string,
+	m BlockManifest,
+	sources []StoredBlock,
+) (BlockManifest, error) {
+	ids := map[string]bool{}
+	for _, b := range sources {
+		ids[BlockIDOf(filepath.Base(b.Path))] = true
+	}
+
+	entries, err := catalog.Query(ctx, CatalogQuery{DestKeys: []string{destKey}, From: m.Start, To: m.End})
+	if err != nil {
+		return m, fmt.Errorf("catalog: %w", err)
+	}
+
+	for _, e := range entries {
+		if ids[e.ID] {
+			m = m.merge(e.manifest())
+		}
+	}
+
+	return m, nil
+}
+
+func mergeSource(m blockMerger, b StoredBlock, kw KeyWrapper) (int, error) {
+	f, err := os.Open(b.Path)
+	if err != nil {
+		return 0, err
+	}
+
+	defer f.Close()
+
+	r, err := OpenBlock(bufio.NewReader(f), filepath.Base(b.Path), kw)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", b.Path, err)
+	}
+
+	n, err := m.merge(r)
+	if err != nil {
+		return n, fmt.Errorf("%s: %w", b.Path, err)
+	}
+
+	return n, nil
+}
+
+// blockMerger appends the rows of plain (i.e. decrypted and decompressed) blocks to a single output
+type blockMerger interface {
+	merge(r io.Reader) (int, error)
+	flush() error
+}
+
+type csvHeaderMode int
+
+const (
+	csvHeader         csvHeaderMode = iota // every block starts with the same header
+	csvNoHeader                            // blocks don't have a header
+	csvRepeatedHeader                      // a first row matching the first row of the first block is a header
+)
+
+func newBlockMerger(suffix string, out io.Writer, header csvHeaderMode) (blockMerger, error) {
+	switch suffix {
+	case "json", "ndjson", "jsonl":
+		return &ndjsonMerger{out: out}, nil
+	case "csv":
+		return &csvMerger{out: csv.NewWriter(out), mode: header}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", errUnsupportedFormat, suffix)
+	}
+}
+
+type ndjsonMerger struct {
+	out io.Writer
+}
+
+func (m *ndjsonMerger) merge(r io.Reader) (int, error) {
+	var (
+		rows int
+		in   = bufio.NewReader(r)
+	)
+
+	for {
+		line, err := in.ReadBytes('\n')
+
+		if len(bytes.TrimSpace(line)) > 0 {
+			if line[len(line)-1] != '\n' {
+				line = append(line, '\n')
+			}
+
+			if _, err := m.out.Write(line); err != nil {
+				return rows, err
+			}
+
+			rows++
+		}
+
+		if err == io.EOF {
+			return rows, nil
+		}
+
+		if err != nil {
+			return rows, err
+		}
+	}
+}
+
+func (m *ndjsonMerger) flush() error { return nil }
+
+// csvMerger keeps the header of the first block and drops the (identical) headers of the following blocks
+type csvMerger struct {
+	out    *csv.Writer
+	mode   csvHeaderMode
+	header []string
+}
+
+func (m *csvMerger) merge(r io.Reader) (int, error) {
+	var rows int
+
+	in := csv.NewReader(r)
+	in.FieldsPerRecord = -1
+
+	for first := true; ; first = false {
+		record, err := in.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+
+		if err != nil {
+			return rows, err
+		}
+
+		switch {
+		case !first || m.mode == csvNoHeader:
+			rows++
+		case m.header == nil:
+			m.header = record
+			// without knowing whether there is a header, the first row can only be counted as a row
+			if m.mode == csvRepeatedHeader {
+				rows++
+			}
+		case slices.Equal(m.header, record):
+			continue
+		case m.mode == csvHeader:
+			return rows, fmt.Errorf("header %v doesn't match %v", record, m.header)
+		default:
+			rows++
+		}
+
+		if err := m.out.Write(record); err != nil {
+			return rows, err
+		}
+	}
+}
+
+func (m *csvMerger) flush() error {
+	m.out.Flush()
+	return m.out.Error()
+}
@@ -0,0 +1,277 @@
+package jetcapture
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeBlockFile(t *testing.T, root, name string, compression Compression, kw KeyWrapper, content string) {
+	p := filepath.Join(root, filepath.FromSlash(name))
+	require.Nil(t, os.MkdirAll(filepath.Dir(p), 0755))
+
+	buf, err := wrapBuffer(newMemoryBuffer(), compression, kw)
+	require.Nil(t, err)
+
+	_, err = io.WriteString(buf, content)
+	require.Nil(t, err)
+	require.Nil(t, buf.DoneWriting())
+
+	b, err := io.ReadAll(buf)
+	require.Nil(t, err)
+	require.Nil(t, os.WriteFile(p, b, 0644))
+}
+
+func readBlockFile(t *testing.T, p string, kw KeyWrapper) string {
+	f, err := os.Open(p)
+	require.Nil(t, err)
+	defer f.Close()
+
+	r, err := OpenBlock(f, filepath.Base(p), kw)
+	require.Nil(t, err)
+
+	b, err := io.ReadAll(r)
+	require.Nil(t, err)
+
+	return string(b)
+}
+
+func TestParseBlockFileName(t *testing.T) {
+	assert := require.New(t)
+
+	for name, expected := range map[string]struct {
+		suffix      string
+		compression Compression
+		encrypted   bool
+	}{
+		"backup-1.csv":             {"csv", None, false},
+		"backup-1.json.gz":         {"json", GZip, false},
+		"backup-1.json.snappy.enc": {"json", Snappy, true},
+		"backup":                   {"", None, false},
+	} {
+		suffix, compression, encrypted := ParseBlockFileName(name)
+		assert.Equal(expected.suffix, suffix, name)
+		assert.Equal(expected.compression, compression, name)
+		assert.Equal(expected.encrypted, encrypted, name)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	assert := require.New(t)
+
+	kw, err := NewLocalKeyWrapper(bytes.Repeat([]byte{1}, 32))
+	assert.Nil(err)
+
+	root := t.TempDir()
+
+	writeBlockFile(t, root, "csv/2024/01/02/03/00/backup-1.csv.gz", GZip, nil, "a,b\n1,2\n")
+	writeBlockFile(t, root, "csv/2024/01/02/03/05/backup-2.csv.gz", GZip, nil, "a,b\n3,\"4\n5\"\n")
+	writeBlockFile(t, root, "csv/2024/01/02/04/00/backup-3.csv.gz", GZip, nil, "a,b\n6,7\n")
+	writeBlockFile(t, root, "json/2024/01/02/03/00/backup-4.json.enc", None, kw, "{\"a\":1}\n")
+	writeBlockFile(t, root, "json/2024/01/02/03/05/backup-5.json.enc", None, kw, "{\"a\":2}")
+	writeBlockFile(t, root, "json/2024/01/02/03/10/backup-6.json", None, nil, "{\"a\":3}\n")
+	writeBlockFile(t, root, "bin/2024/01/02/03/00/backup-7.bin", None, nil, "x")
+	writeBlockFile(t, root, "bin/2024/01/02/03/05/backup-8.bin", None, nil, "y")
+	// the current hour isn't complete yet
+	writeBlockFile(t, root, "json/2024/01/02/05/00/backup-9.json", None, nil, "{\"a\":4}\n")
+	writeBlockFile(t, root, "json/2024/01/02/05/05/backup-10.json", None, nil, "{\"a\":5}\n")
+
+	store := &LocalFSStore[string]{
+		Resolver: func(dk string) (string, error) {
+			return filepath.Join(root, dk), nil
+		},
+	}
+
+	opts := CompactOptions{
+		Period:     time.Hour,
+		KeyWrapper: kw,
+		TempDir:    t.TempDir(),
+		DryRun:     true,
+		Location:   time.UTC,
+		Now:        func() time.Time { return time.Date(2024, 1, 2, 5, 30, 0, 0, time.UTC) },
+	}
+
+	identity := func(destDir string) (string, error) { return destDir, nil }
+
+	results, err := Compact[string](context.Background(), root, store, identity, opts)
+	assert.Nil(err)
+	assert.Len(results, 3)
+	assert.FileExists(filepath.Join(root, "csv/2024/01/02/03/00/backup-1.csv.gz"))
+
+	opts.DryRun = false
+
+	results, err = Compact[string](context.Background(), root, store, identity, opts)
+	assert.Nil(err)
+	assert.Len(results, 2)
+
+	csvResult := results[0]
+	assert.Equal(2, csvResult.Rows)
+	assert.Len(csvResult.Sources, 2)
+	assert.Equal(filepath.Join(root, "csv/2024/01/02/03/00"), filepath.Dir(csvResult.Path))
+	assert.Regexp(`^compacted-[0-9A-Z]{26}\.csv\.gz$`, filepath.Base(csvResult.Path))
+	assert.Equal("a,b\n1,2\n3,\"4\n5\"\n", readBlockFile(t, csvResult.Path, nil))
+
+	// the merged encrypted block is still encrypted
+	jsonResult := results[1]
+	assert.Equal(2, jsonResult.Rows)
+	assert.Regexp(`\.json\.enc$`, jsonResult.Path)
+	assert.Equal("{\"a\":1}\n{\"a\":2}\n", readBlockFile(t, jsonResult.Path, kw))
+
+	blocks, err := ListBlocks(root, time.UTC)
+	assert.Nil(err)
+
+	var remaining []string
+	for _, b := range blocks {
+		rel, _ := filepath.Rel(root, b.Path)
+		remaining = append(remaining, filepath.ToSlash(rel))
+	}
+
+	assert.ElementsMatch([]string{
+		"bin/2024/01/02/03/00/backup-7.bin",
+		"bin/2024/01/02/03/05/backup-8.bin",
+		"csv/2024/01/02/03/00/" + filepath.Base(csvResult.Path),
+		"csv/2024/01/02/04/00/backup-3.csv.gz",
+		"json/2024/01/02/03/00/" + filepath.Base(jsonResult.Path),
+		"json/2024/01/02/03/10/backup-6.json",
+		"json/2024/01/02/05/00/backup-9.json",
+		"json/2024/01/02/05/05/backup-10.json",
+	}, remaining)
+
+	assert.NoDirExists(filepath.Join(root, "csv/2024/01/02/03/05"))
+}
+
+func TestCompactCatalog(t *testing.T) {
+	assert := require.New(t)
+
+	root := t.TempDir()
+
+	writeBlockFile(t, root, "csv/2024/01/02/03/00/backup-1.csv", None, nil, "a,b\n1,2\n")
+	writeBlockFile(t, root, "csv/2024/01/02/03/05/late-2.csv", None, nil, "a,b\n3,4\n")
+	writeBlockFile(t, root, "csv/2024/01/02/04/00/backup-3.csv", None, nil, "a,b\n5,6\n")
+
+	catalog, err := OpenBoltCatalog(filepath.Join(t.TempDir(), "catalog.db"))
+	assert.Nil(err)
+	t.Cleanup(func() { _ = catalog.Close() })
+
+	ctx := context.Background()
+	t0 := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+
+	for i, e := range []CatalogEntry{
+		{Start: t0, OldestMessage: t0.Add(time.Minute), NewestMessage: t0.Add(4 * time.Minute), FirstSeq: 10, LastSeq: 11},
+		// the late block holds a message from the previous hour
+		{Start: t0.Add(5 * time.Minute), OldestMessage: t0.Add(-time.Minute), NewestMessage: t0.Add(6 * time.Minute), FirstSeq: 3, LastSeq: 20},
+		{Start: t0.Add(time.Hour), OldestMessage: t0.Add(time.Hour), NewestMessage: t0.Add(time.Hour), FirstSeq: 30, LastSeq: 30},
+	} {
+		blocks, err := ListBlocks(root, time.UTC)
+		assert.Nil(err)
+
+		e.DestKey = "csv"
+		e.Path = blocks[i].Path
+		e.ID = BlockIDOf(filepath.Base(e.Path))
+		e.End = e.Start.Add(5 * time.Minute)
+		e.MessageCount = 1
+		e.RowCount = 1
+
+		assert.Nil(catalog.Add(ctx, e))
+	}
+
+	store := &LocalFSStore[string]{
+		Resolver: func(dk string) (string, error) {
+			return filepath.Join(root, dk), nil
+		},
+	}
+
+	results, err := Compact[string](ctx, root, store, func(destDir string) (string, error) {
+		return destDir, nil
+	}, CompactOptions{
+		Period:   time.Hour,
+		TempDir:  t.TempDir(),
+		Location: time.UTC,
+		Catalog:  catalog,
+		Now:      func() time.Time { return time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC) },
+	})
+	assert.Nil(err)
+	assert.Len(results, 1)
+
+	entries, err := catalog.Query(ctx, CatalogQuery{})
+	assert.Nil(err)
+	assert.Len(entries, 2)
+
+	merged := entries[0]
+	assert.Equal(results[0].Path, merged.Path)
+	assert.Equal(BlockIDOf(filepath.Base(merged.Path)), merged.ID)
+	assert.Equal(t0, merged.Start)
+	assert.Equal(t0.Add(time.Hour), merged.End)
+	assert.Equal(t0.Add(-time.Minute), merged.OldestMessage)
+	assert.Equal(t0.Add(6*time.Minute), merged.NewestMessage)
+	assert.EqualValues(3, merged.FirstSeq)
+	assert.EqualValues(20, merged.LastSeq)
+	assert.Equal(2, merged.MessageCount)
+	assert.Equal(2, merged.RowCount)
+	assert.Equal(results[0].Bytes, merged.Size)
+
+	assert.Equal("3", entries[1].ID)
+
+	// the late message is still found by its time
+	entries, err = catalog.Query(ctx, CatalogQuery{To: t0})
+	assert.Nil(err)
+	assert.Len(entries, 1)
+	assert.Equal(merged.ID, entries[0].ID)
+}
+
+func TestCompactHeaderMismatch(t *testing.T) {
+	assert := require.New(t)
+
+	root := t.TempDir()
+
+	writeBlockFile(t, root, "2024/01/02/03/00/backup-1.csv", None, nil, "a,b\n1,2\n")
+	writeBlockFile(t, root, "2024/01/02/03/05/backup-2.csv", None, nil, "a,c\n3,4\n")
+
+	_, err := Compact[string](context.Background(), root, SingleDirStore[string](root), func(string) (string, error) {
+		return "", nil
+	}, CompactOptions{Location: time.UTC, TempDir: t.TempDir()})
+	assert.ErrorContains(err, "doesn't match")
+
+	// nothing was removed
+	blocks, err := ListBlocks(root, time.UTC)
+	assert.Nil(err)
+	assert.Len(blocks, 2)
+}
+
+func TestCompactDST(t *testing.T) {
+	assert := require.New(t)
+
+	oslo, err := time.LoadLocation("Europe/Oslo")
+	assert.Nil(err)
+
+	root := t.TempDir()
+
+	// the day DST ends is 25 hours long
+	writeBlockFile(t, root, "2024/10/27/00/00/backup-1.json", None, nil, "{\"a\":1}\n")
+	writeBlockFile(t, root, "2024/10/27/23/00/backup-2.json", None, nil, "{\"a\":2}\n")
+	writeBlockFile(t, root, "2024/10/28/00/00/backup-3.json", None, nil, "{\"a\":3}\n")
+	writeBlockFile(t, root, "2024/10/28/01/00/backup-4.json", None, nil, "{\"a\":4}\n")
+
+	results, err := Compact[string](context.Background(), root, SingleDirStore[string](root), func(string) (string, error) {
+		return "", nil
+	}, CompactOptions{
+		Location: oslo,
+		TempDir:  t.TempDir(),
+		Now:      func() time.Time { return time.Date(2024, 10, 29, 12, 0, 0, 0, oslo) },
+	})
+	assert.Nil(err)
+	assert.Len(results, 2)
+
+	for i, day := range []int{27, 28} {
+		assert.True(results[i].Start.Equal(time.Date(2024, 10, day, 0, 0, 0, 0, oslo)), results[i].Start)
+		assert.Equal(2, results[i].Rows)
+	}
+
+	assert.Equal("{\"a\":1}\n{\"a\":2}\n", readBlockFile(t, results[0].Path, nil))
+}
@@ -1,6 +1,11 @@
 package jetcapture
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+
 	"github.com/nats-io/nats.go"
 	"golang.org/x/exp/maps"
 )
@@ -37,3 +42,73 @@ func NatsToNats[K DestKey](resolve func(msg *nats.Msg) K) func(msg *nats.Msg) (*
 }
 
 func SubjectToDestKey(msg *nats.Msg) string { return msg.Subject }
+
+// JSONDecoderOption changes the behaviour of a decoder created by JSONDecoder
+type JSONDecoderOption func(cfg *jsonDecoderConfig)
+
+type jsonDecoderConfig struct {
+	strict    bool
+	useNumber bool
+}
+
+// JSONStrict rejects payloads containing fields that are not present in the payload type, as well as trailing data
+func JSONStrict() JSONDecoderOption {
+	return func(cfg *jsonDecoderConfig) {
+		cfg.strict = true
+	}
+}
+
+// JSONUseNumber decodes numbers into `json.Number` instead of `float64` (e.g. for `map[string]any` payloads) so that
+// numeric precision survives the round trip
+func JSONUseNumber() JSONDecoderOption {
+	return func(cfg *jsonDecoderConfig) {
+		cfg.useNumber = true
+	}
+}
+
+// JSONDecoder returns a `MessageDecoder` that unmarshals the message data as JSON into P and resolves the DestKey using
+// the provided resolver (e.g. JSONPointerKey, SubjectTokenKey or HeaderKey). P may be a value or a pointer type, which
+// is allocated as needed. A nil resolver always returns the zero DestKey.
+func JSONDecoder[P Payload, K DestKey](resolve KeyResolver[K], options ...JSONDecoderOption) func(msg *nats.Msg) (P, K, error) {
+	var cfg jsonDecoderConfig
+
+	for _, o := range options {
+		o(&cfg)
+	}
+
+	return func(msg *nats.Msg) (P, K, error) {
+		var (
+			payload P
+			dk      K
+			err     error
+		)
+
+		dec := json.NewDecoder(bytes.NewReader(msg.Data))
+
+		if cfg.strict {
+			dec.DisallowUnknownFields()
+		}
+
+		if cfg.useNumber {
+			dec.UseNumber()
+		}
+
+		if err = dec.Decode(&payload); err != nil {
+			return payload, dk, err
+		}
+
+		if cfg.strict {
+			if _, err = dec.Token(); err != io.EOF {
+				return payload, dk, errors.New("unexpected data after top-level JSON value")
+			}
+		}
+
+		if resolve != nil {
+			if dk, err = resolve(msg); err != nil {
+				return payload, dk, err
+			}
+		}
+
+		return payload, dk, nil
+	}
+}
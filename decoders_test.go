@@ -0,0 +1,79 @@
+package jetcapture
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONDecoder(t *testing.T) {
+	assert := require.New(t)
+
+	msg := &nats.Msg{
+		Subject: "orders.store42.created",
+		Header:  nats.Header{"Region": []string{"eu"}},
+		Data:    []byte(`{"customer_name": "a", "order_id": 12345678901234567890, "store": {"id": 42}}`),
+	}
+
+	type order struct {
+		CustomerName string `json:"customer_name"`
+		OrderID      uint64 `json:"order_id"`
+	}
+
+	p, dk, err := JSONDecoder[*order](JSONPointerKey("/store/id"))(msg)
+	assert.Nil(err)
+	assert.Equal("42", dk)
+	assert.Equal(uint64(12345678901234567890), p.OrderID)
+
+	_, dk, err = JSONDecoder[order](JSONPointerKey("store.id"))(msg)
+	assert.Nil(err)
+	assert.Equal("42", dk)
+
+	_, dk, err = JSONDecoder[order](SubjectTokenKey(1))(msg)
+	assert.Nil(err)
+	assert.Equal("store42", dk)
+
+	_, dk, err = JSONDecoder[order](HeaderKey("Region"))(msg)
+	assert.Nil(err)
+	assert.Equal("eu", dk)
+
+	_, _, err = JSONDecoder[order](HeaderKey("Missing"))(msg)
+	assert.True(errors.Is(err, ErrKeyNotFound))
+
+	_, _, err = JSONDecoder[order](SubjectTokenKey(3))(msg)
+	assert.True(errors.Is(err, ErrKeyNotFound))
+
+	_, _, err = JSONDecoder[order](StaticKey("x"), JSONStrict())(msg)
+	assert.NotNil(err)
+
+	m, _, err := JSONDecoder[map[string]any](StaticKey("x"), JSONUseNumber())(msg)
+	assert.Nil(err)
+	assert.Equal(json.Number("12345678901234567890"), m["order_id"])
+
+	b, err := json.Marshal(m)
+	assert.Nil(err)
+	assert.Contains(string(b), `"order_id":12345678901234567890`)
+}
+
+func TestJSONPointerKey(t *testing.T) {
+	assert := require.New(t)
+
+	msg := &nats.Msg{Data: []byte(`{"a/b": {"c~d": [true, {"e": "f"}]}, "obj": {}}`)}
+
+	v, err := JSONPointerKey("/a~1b/c~0d/0")(msg)
+	assert.Nil(err)
+	assert.Equal("true", v)
+
+	v, err = JSONPointerKey("/a~1b/c~0d/1/e")(msg)
+	assert.Nil(err)
+	assert.Equal("f", v)
+
+	_, err = JSONPointerKey("/a~1b/c~0d/2")(msg)
+	assert.True(errors.Is(err, ErrKeyNotFound))
+
+	_, err = JSONPointerKey("/obj")(msg)
+	assert.NotNil(err)
+}
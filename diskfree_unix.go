@@ -0,0 +1,14 @@
+//go:build unix
+
+package jetcapture
+
+import "golang.org/x/sys/unix"
+
+// diskFree returns the number of bytes available to unprivileged users on the file system containing path
+func diskFree(path string) (uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
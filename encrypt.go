@@ -0,0 +1,373 @@
+package jetcapture
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// KeyWrapper protects the per-block data keys used for client-side encryption (e.g. using a local key file or a KMS)
+type KeyWrapper interface {
+	// WrapKey encrypts a data key. The returned key id is stored alongside the wrapped key and passed back to UnwrapKey.
+	WrapKey(dataKey []byte) (keyID string, wrapped []byte, err error)
+
+	// UnwrapKey decrypts a data key previously wrapped by WrapKey
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+const (
+	encryptionMagic     = "JCAPENC1"
+	encryptionChunkSize = 64 * 1024
+	dataKeySize         = 32 // AES-256
+)
+
+var (
+	ErrDecryption = errors.New("unable to decrypt block")
+
+	_ KeyWrapper = &LocalKeyWrapper{}
+)
+
+// LocalKeyWrapper wraps data keys with a static AES-256 key encryption key, typically loaded from a local file
+type LocalKeyWrapper struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewLocalKeyWrapper creates a KeyWrapper from a 32 byte key encryption key
+func NewLocalKeyWrapper(key []byte) (*LocalKeyWrapper, error) {
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", dataKeySize, len(key))
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// the key id is derived from the key so restore tooling can tell which key a block needs
+	sum := sha256.Sum256(key)
+
+	return &LocalKeyWrapper{
+		id:   "local:" + hex.EncodeToString(sum[:8]),
+		aead: aead,
+	}, nil
+}
+
+// NewLocalKeyWrapperFromFile loads a key encryption key from a file containing 32 raw bytes, or the key encoded as
+// hex or base64 (e.g. `openssl rand -hex 32 > capture.key`)
+func NewLocalKeyWrapperFromFile(path string) (*LocalKeyWrapper, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) != dataKeySize {
+		trimmed := string(bytes.TrimSpace(b))
+		if b, err = hex.DecodeString(trimmed); err != nil {
+			if b, err = base64.StdEncoding.DecodeString(trimmed); err != nil {
+				return nil, fmt.Errorf("%s: key must be 32 raw bytes, hex or base64", path)
+			}
+		}
+	}
+
+	return NewLocalKeyWrapper(b)
+}
+
+func (l *LocalKeyWrapper) WrapKey(dataKey []byte) (string, []byte, error) {
+	nonce := make([]byte, l.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return _EMPTY_, nil, err
+	}
+
+	return l.id, l.aead.Seal(nonce, nonce, dataKey, []byte(l.id)), nil
+}
+
+func (l *LocalKeyWrapper) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	if keyID != l.id {
+		return nil, fmt.Errorf("%w: block was encrypted with key %q, not %q", ErrDecryption, keyID, l.id)
+	}
+
+	ns := l.aead.NonceSize()
+	if len(wrapped) < ns {
+		return nil, ErrDecryption
+	}
+
+	dataKey, err := l.aead.Open(nil, wrapped[:ns], wrapped[ns:], []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecryption, err)
+	}
+
+	return dataKey, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptedHeader is written at the start of every encrypted block:
+//
+//	magic | uint16 len | key id | uint16 len | wrapped data key | nonce prefix
+//
+// it is followed by chunks of `uint32 len | AES-256-GCM sealed data`. Each chunk nonce is the prefix, a chunk counter
+// and a final-chunk flag, so reordered, dropped or truncated chunks fail to decrypt. The header is authenticated as
+// additional data of every chunk.
+type encryptedHeader struct {
+	keyID       string
+	wrappedKey  []byte
+	noncePrefix [7]byte
+}
+
+func (h *encryptedHeader) marshal() []byte {
+	var b []byte
+	b = append(b, encryptionMagic...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(h.keyID)))
+	b = append(b, h.keyID...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(h.wrappedKey)))
+	b = append(b, h.wrappedKey...)
+	return append(b, h.noncePrefix[:]...)
+}
+
+func readEncryptedHeader(r io.Reader) (*encryptedHeader, []byte, error) {
+	var (
+		h   encryptedHeader
+		raw bytes.Buffer
+		tr  = io.TeeReader(r, &raw)
+	)
+
+	magic := make([]byte, len(encryptionMagic))
+	if _, err := io.ReadFull(tr, magic); err != nil || string(magic) != encryptionMagic {
+		return nil, nil, fmt.Errorf("%w: not an encrypted block", ErrDecryption)
+	}
+
+	readField := func() ([]byte, error) {
+		var l uint16
+		if err := binary.Read(tr, binary.BigEndian, &l); err != nil {
+			return nil, err
+		}
+		b := make([]byte, l)
+		_, err := io.ReadFull(tr, b)
+		return b, err
+	}
+
+	keyID, err := readField()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrDecryption, err)
+	}
+
+	if h.wrappedKey, err = readField(); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrDecryption, err)
+	}
+
+	if _, err := io.ReadFull(tr, h.noncePrefix[:]); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrDecryption, err)
+	}
+
+	h.keyID = string(keyID)
+
+	return &h, raw.Bytes(), nil
+}
+
+func chunkNonce(prefix [7]byte, counter uint32, final bool) []byte {
+	nonce := make([]byte, 0, 12)
+	nonce = append(nonce, prefix[:]...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if final {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// encryptingWriter encrypts everything written to it using a fresh data key. `Close` must be called to write the
+// final chunk, it does not close the underlying writer.
+type encryptingWriter struct {
+	out     io.Writer
+	aead    cipher.AEAD
+	header  *encryptedHeader
+	aad     []byte
+	buf     []byte
+	counter uint32
+	closed  bool
+}
+
+func newEncryptingWriter(out io.Writer, kw KeyWrapper) (*encryptingWriter, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &encryptedHeader{}
+
+	if h.keyID, h.wrappedKey, err = kw.WrapKey(dataKey); err != nil {
+		return nil, err
+	}
+
+	if _, err := rand.Read(h.noncePrefix[:]); err != nil {
+		return nil, err
+	}
+
+	w := &encryptingWriter{
+		out:    out,
+		aead:   aead,
+		header: h,
+		aad:    h.marshal(),
+		buf:    make([]byte, 0, encryptionChunkSize),
+	}
+
+	if _, err := out.Write(w.aad); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *encryptingWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed encrypting writer")
+	}
+
+	n := len(p)
+
+	for len(p) > 0 {
+		// a full chunk is only sealed once more data arrives, so the last chunk can always be marked as final
+		if len(w.buf) == encryptionChunkSize {
+			if err := w.seal(false); err != nil {
+				return n - len(p), err
+			}
+		}
+
+		c := copy(w.buf[len(w.buf):encryptionChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+	}
+
+	return n, nil
+}
+
+func (w *encryptingWriter) seal(final bool) error {
+	sealed := w.aead.Seal(nil, chunkNonce(w.header.noncePrefix, w.counter, final), w.buf, w.aad)
+
+	if _, err := w.out.Write(binary.BigEndian.AppendUint32(nil, uint32(len(sealed)))); err != nil {
+		return err
+	}
+
+	if _, err := w.out.Write(sealed); err != nil {
+		return err
+	}
+
+	w.counter++
+	w.buf = w.buf[:0]
+
+	return nil
+}
+
+func (w *encryptingWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.seal(true)
+}
+
+type decryptingReader struct {
+	in      *bufio.Reader
+	aead    cipher.AEAD
+	header  *encryptedHeader
+	aad     []byte
+	plain   []byte
+	counter uint32
+	done    bool
+}
+
+// NewDecryptingReader returns a reader for restore tooling that decrypts a block written with `Options.Encryption`.
+// The KeyWrapper must be able to unwrap the block's data key. Any tampering or truncation results in ErrDecryption.
+func NewDecryptingReader(r io.Reader, kw KeyWrapper) (io.Reader, error) {
+	in := bufio.NewReader(r)
+
+	h, aad, err := readEncryptedHeader(in)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := kw.UnwrapKey(h.keyID, h.wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptingReader{
+		in:     in,
+		aead:   aead,
+		header: h,
+		aad:    aad,
+	}, nil
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+
+	return n, nil
+}
+
+func (d *decryptingReader) next() error {
+	var l uint32
+	if err := binary.Read(d.in, binary.BigEndian, &l); err != nil {
+		return fmt.Errorf("%w: truncated block: %v", ErrDecryption, err)
+	}
+
+	if l > encryptionChunkSize+uint32(d.aead.Overhead()) {
+		return fmt.Errorf("%w: invalid chunk size %d", ErrDecryption, l)
+	}
+
+	sealed := make([]byte, l)
+	if _, err := io.ReadFull(d.in, sealed); err != nil {
+		return fmt.Errorf("%w: truncated block: %v", ErrDecryption, err)
+	}
+
+	// the final chunk is the one not followed by any more data
+	_, err := d.in.Peek(1)
+	final := err == io.EOF
+
+	plain, err := d.aead.Open(nil, chunkNonce(d.header.noncePrefix, d.counter, final), sealed, d.aad)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d: %v", ErrDecryption, d.counter, err)
+	}
+
+	d.counter++
+	d.plain = plain
+	d.done = final
+
+	return nil
+}
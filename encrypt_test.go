@@ -0,0 +1,180 @@
+package jetcapture
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/stretchr/testify/require"
+)
+
+func testKeyWrapper(assert *require.Assertions) *LocalKeyWrapper {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	assert.Nil(err)
+
+	kw, err := NewLocalKeyWrapper(key)
+	assert.Nil(err)
+
+	return kw
+}
+
+func encryptBytes(assert *require.Assertions, kw KeyWrapper, plain []byte) []byte {
+	var buf bytes.Buffer
+
+	w, err := newEncryptingWriter(&buf, kw)
+	assert.Nil(err)
+
+	// write in odd sized pieces to exercise the chunking
+	for len(plain) > 0 {
+		n := min(len(plain), 1000)
+		_, err := w.Write(plain[:n])
+		assert.Nil(err)
+		plain = plain[n:]
+	}
+
+	assert.Nil(w.Close())
+
+	return buf.Bytes()
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	assert := require.New(t)
+
+	kw := testKeyWrapper(assert)
+
+	for _, sz := range []int{0, 1, encryptionChunkSize, encryptionChunkSize + 1, 3*encryptionChunkSize + 17} {
+		plain := make([]byte, sz)
+		_, err := rand.Read(plain)
+		assert.Nil(err)
+
+		encrypted := encryptBytes(assert, kw, plain)
+
+		r, err := NewDecryptingReader(bytes.NewReader(encrypted), kw)
+		assert.Nil(err)
+
+		decrypted, err := io.ReadAll(r)
+		assert.Nil(err)
+		assert.Equal(plain, decrypted, "size %d", sz)
+	}
+}
+
+func TestEncryptionTampering(t *testing.T) {
+	assert := require.New(t)
+
+	kw := testKeyWrapper(assert)
+
+	plain := bytes.Repeat([]byte("jetcapture"), encryptionChunkSize)
+	encrypted := encryptBytes(assert, kw, plain)
+
+	decrypt := func(b []byte, kw KeyWrapper) error {
+		r, err := NewDecryptingReader(bytes.NewReader(b), kw)
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadAll(r)
+		return err
+	}
+
+	assert.Nil(decrypt(encrypted, kw))
+
+	// wrong key
+	assert.True(errors.Is(decrypt(encrypted, testKeyWrapper(assert)), ErrDecryption))
+
+	// truncated at a chunk boundary
+	_, aad, err := readEncryptedHeader(bytes.NewReader(encrypted))
+	assert.Nil(err)
+	firstChunk := len(aad) + 4 + encryptionChunkSize + 16
+	assert.True(errors.Is(decrypt(encrypted[:firstChunk], kw), ErrDecryption))
+
+	// truncated mid chunk
+	assert.True(errors.Is(decrypt(encrypted[:len(encrypted)-5], kw), ErrDecryption))
+
+	// flipped bit
+	tampered := append([]byte(nil), encrypted...)
+	tampered[len(tampered)-100] ^= 1
+	assert.True(errors.Is(decrypt(tampered, kw), ErrDecryption))
+
+	// not encrypted at all
+	assert.True(errors.Is(decrypt(plain, kw), ErrDecryption))
+}
+
+func TestLocalKeyWrapperFromFile(t *testing.T) {
+	assert := require.New(t)
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	assert.Nil(err)
+
+	dir := t.TempDir()
+
+	raw := filepath.Join(dir, "raw.key")
+	assert.Nil(os.WriteFile(raw, key, 0600))
+
+	hexed := filepath.Join(dir, "hex.key")
+	assert.Nil(os.WriteFile(hexed, []byte(hex.EncodeToString(key)+"\n"), 0600))
+
+	short := filepath.Join(dir, "short.key")
+	assert.Nil(os.WriteFile(short, []byte("abc"), 0600))
+
+	kw1, err := NewLocalKeyWrapperFromFile(raw)
+	assert.Nil(err)
+
+	kw2, err := NewLocalKeyWrapperFromFile(hexed)
+	assert.Nil(err)
+
+	_, err = NewLocalKeyWrapperFromFile(short)
+	assert.NotNil(err)
+
+	id, wrapped, err := kw1.WrapKey([]byte("data key"))
+	assert.Nil(err)
+
+	unwrapped, err := kw2.UnwrapKey(id, wrapped)
+	assert.Nil(err)
+	assert.Equal("data key", string(unwrapped))
+}
+
+func TestEncryptedCompressedBuffer(t *testing.T) {
+	assert := require.New(t)
+
+	kw := testKeyWrapper(assert)
+
+	c := New(Options[string, string]{
+		Compression:  GZip,
+		Encryption:   kw,
+		BufferToDisk: true,
+		TempDir:      t.TempDir(),
+		Suffix:       "txt",
+	})
+
+	assert.Equal("txt.gz.enc", c.fileSuffix())
+
+	buf, _, err := c.makeBuffer()
+	assert.Nil(err)
+
+	defer func() {
+		assert.Nil(buf.Remove())
+	}()
+
+	plain := bytes.Repeat([]byte("hello world\n"), 50000)
+
+	_, err = buf.Write(plain)
+	assert.Nil(err)
+	assert.Nil(buf.DoneWriting())
+
+	r, err := NewDecryptingReader(buf, kw)
+	assert.Nil(err)
+
+	gz, err := gzip.NewReader(r)
+	assert.Nil(err)
+
+	decoded, err := io.ReadAll(gz)
+	assert.Nil(err)
+	assert.Equal(plain, decoded)
+}
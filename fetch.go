@@ -0,0 +1,134 @@
+package jetcapture
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// setConsumer records the latest consumer info, which limits the fetch requests
+func (c *Capture[P, K]) setConsumer(ci *nats.ConsumerInfo) {
+	if prev := c.consumer; prev != nil && (prev.Config.MaxRequestBatch != ci.Config.MaxRequestBatch ||
+		prev.Config.MaxRequestMaxBytes != ci.Config.MaxRequestMaxBytes ||
+		prev.Config.MaxRequestExpires != ci.Config.MaxRequestExpires ||
+		prev.Config.MaxAckPending != ci.Config.MaxAckPending) {
+		log.Infow("consumer config changed",
+			"max_request_batch", ci.Config.MaxRequestBatch,
+			"max_request_max_bytes", ci.Config.MaxRequestMaxBytes,
+			"max_request_expires", ci.Config.MaxRequestExpires,
+			"max_ack_pending", ci.Config.MaxAckPending,
+		)
+	}
+
+	c.consumer = ci
+	c.consumerAt = time.Now()
+}
+
+// refreshConsumer reads the consumer info again once `Options.ConsumerRefresh` passed, so config changes (e.g. a
+// lower MaxRequestBatch) are picked up without a restart
+func (c *Capture[P, K]) refreshConsumer(ctx context.Context) {
+	if time.Since(c.consumerAt) < c.opts.ConsumerRefresh {
+		return
+	}
+
+	ci, err := c.consumerInfo(ctx)
+	if err != nil {
+		// keep using the previous info, and try again after another interval
+		log.Warnw("unable to refresh consumer info", "error", err)
+		c.consumerAt = time.Now()
+		return
+	}
+
+	c.setConsumer(ci)
+}
+
+// maxBatch returns `Options.FetchBatch` (or DefaultFetchBatch) limited to the consumer's MaxRequestBatch
+func (c *Capture[P, K]) maxBatch() int {
+	batch := c.opts.FetchBatch
+
+	if c.consumer != nil {
+		if limit := c.consumer.Config.MaxRequestBatch; limit > 0 && (batch == 0 || batch > limit) {
+			batch = limit
+		}
+	}
+
+	if batch <= 0 {
+		batch = DefaultFetchBatch
+	}
+
+	return batch
+}
+
+// nextBatch returns the size of the next fetch request
+func (c *Capture[P, K]) nextBatch() int {
+	limit := c.maxBatch()
+
+	if !c.opts.AdaptiveFetch {
+		return limit
+	}
+
+	if c.batch <= 0 || c.batch > limit {
+		c.batch = limit
+	}
+
+	return c.batch
+}
+
+// adaptBatch doubles the AdaptiveFetch batch when a full batch arrived within a quarter of the fetch timeout, and
+// halves it when a fetch returned less than half a batch or took more than half of the timeout
+func (c *Capture[P, K]) adaptBatch(requested, received int, latency time.Duration) {
+	if !c.opts.AdaptiveFetch {
+		return
+	}
+
+	timeout := c.fetchTimeout()
+
+	switch {
+	case received >= requested && latency < timeout/4:
+		c.batch = min(c.batch*2, c.maxBatch())
+	case received < requested/2 || latency > timeout/2:
+		c.batch = max(c.batch/2, 1)
+	}
+}
+
+// limitBatchToAckRoom shrinks the AdaptiveFetch batch to what the consumer can still deliver before it reaches
+// MaxAckPending. Asking for more only makes fetches wait for the timeout.
+func (c *Capture[P, K]) limitBatchToAckRoom(ci *nats.ConsumerInfo) {
+	if !c.opts.AdaptiveFetch || ci.Config.MaxAckPending <= 0 {
+		return
+	}
+
+	if room := ci.Config.MaxAckPending - ci.NumAckPending; room < c.batch {
+		c.batch = max(room, 1)
+	}
+}
+
+// fetchTimeout returns `Options.FetchTimeout` limited to the consumer's MaxRequestExpires
+func (c *Capture[P, K]) fetchTimeout() time.Duration {
+	timeout := c.opts.FetchTimeout
+	if timeout <= 0 {
+		timeout = DefaultFetchTimeout
+	}
+
+	if c.consumer != nil {
+		if limit := c.consumer.Config.MaxRequestExpires; limit > 0 && timeout > limit {
+			timeout = limit
+		}
+	}
+
+	return timeout
+}
+
+// fetchMaxBytes returns `Options.FetchMaxBytes` limited to the consumer's MaxRequestMaxBytes. 0 means no limit.
+func (c *Capture[P, K]) fetchMaxBytes() int {
+	maxBytes := c.opts.FetchMaxBytes
+
+	if c.consumer != nil {
+		if limit := c.consumer.Config.MaxRequestMaxBytes; limit > 0 && (maxBytes == 0 || maxBytes > limit) {
+			maxBytes = limit
+		}
+	}
+
+	return maxBytes
+}
@@ -0,0 +1,233 @@
+package jetcapture
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchLimits(t *testing.T) {
+	assert := require.New(t)
+
+	c := New[string, string](Options[string, string]{FetchTimeout: 2 * time.Second})
+
+	// an unlimited consumer doesn't mean an unlimited batch
+	assert.Equal(DefaultFetchBatch, c.nextBatch())
+	assert.Equal(2*time.Second, c.fetchTimeout())
+	assert.Zero(c.fetchMaxBytes())
+
+	c.setConsumer(&nats.ConsumerInfo{Config: nats.ConsumerConfig{
+		MaxRequestBatch:    50,
+		MaxRequestExpires:  time.Second,
+		MaxRequestMaxBytes: 1 << 20,
+	}})
+
+	assert.Equal(50, c.nextBatch())
+	assert.Equal(time.Second, c.fetchTimeout())
+	assert.Equal(1<<20, c.fetchMaxBytes())
+
+	c.opts.FetchBatch = 20
+	c.opts.FetchMaxBytes = 1 << 10
+
+	assert.Equal(20, c.nextBatch())
+	assert.Equal(1<<10, c.fetchMaxBytes())
+}
+
+func TestAdaptiveFetch(t *testing.T) {
+	assert := require.New(t)
+
+	c := New[string, string](Options[string, string]{
+		FetchBatch:    64,
+		FetchTimeout:  time.Second,
+		AdaptiveFetch: true,
+	})
+
+	assert.Equal(64, c.nextBatch())
+
+	// a slow fetch halves the batch, even when it's full
+	c.adaptBatch(64, 64, 600*time.Millisecond)
+	assert.Equal(32, c.nextBatch())
+
+	// so does a mostly empty one
+	c.adaptBatch(32, 3, time.Millisecond)
+	assert.Equal(16, c.nextBatch())
+
+	// full and fast batches grow up to FetchBatch
+	for i := 0; i < 5; i++ {
+		c.adaptBatch(c.nextBatch(), c.nextBatch(), time.Millisecond)
+	}
+	assert.Equal(64, c.nextBatch())
+
+	// in between, the batch stays as it is
+	c.adaptBatch(64, 40, 300*time.Millisecond)
+	assert.Equal(64, c.nextBatch())
+
+	c.limitBatchToAckRoom(&nats.ConsumerInfo{
+		Config:        nats.ConsumerConfig{MaxAckPending: 100},
+		NumAckPending: 90,
+	})
+	assert.Equal(10, c.nextBatch())
+
+	c.limitBatchToAckRoom(&nats.ConsumerInfo{
+		Config:        nats.ConsumerConfig{MaxAckPending: 100},
+		NumAckPending: 100,
+	})
+	assert.Equal(1, c.nextBatch())
+
+	for i := 0; i < 20; i++ {
+		c.adaptBatch(1, 0, time.Second)
+	}
+	assert.Equal(1, c.nextBatch())
+}
+
+func TestRefreshConsumer(t *testing.T) {
+	assert := require.New(t)
+
+	cfg := captureTestConfig{
+		messages:        10,
+		maxAckPending:   100,
+		maxRequestBatch: 50,
+		ackWait:         time.Minute,
+		startingOrderID: 1,
+	}
+
+	nc, js, _ := initJetStream(t, cfg)
+
+	c := New[string, string](Options[string, string]{
+		NATSStreamName:   streamName,
+		NATSConsumerName: consumerName,
+		ConsumerRefresh:  time.Hour,
+	})
+	c.nc = nc
+	c.js = js
+
+	ctx := context.Background()
+
+	ci, err := c.consumerInfo(ctx)
+	assert.Nil(err)
+	c.setConsumer(ci)
+	assert.Equal(50, c.nextBatch())
+
+	update := ci.Config
+	update.MaxRequestBatch = 10
+	_, err = js.UpdateConsumer(streamName, &update)
+	assert.Nil(err)
+
+	// not due yet
+	c.refreshConsumer(ctx)
+	assert.Equal(50, c.nextBatch())
+
+	c.opts.ConsumerRefresh = time.Nanosecond
+	c.refreshConsumer(ctx)
+	assert.Equal(10, c.nextBatch())
+}
+
+func TestCaptureAdaptiveFetch(t *testing.T) {
+	assert := require.New(t)
+
+	// the consumer doesn't limit the batch, which Fetch can't be called with
+	cfg := captureTestConfig{
+		messages:        500,
+		maxAckPending:   20000,
+		maxRequestBatch: 0,
+		ackWait:         time.Minute,
+		startingOrderID: 1,
+	}
+
+	_, _, s := initJetStream(t, cfg)
+
+	type (
+		P = map[string]any
+		K = string
+	)
+
+	options := DefaultOptions[P, K]()
+	options.NATSStreamName = streamName
+	options.NATSConsumerName = consumerName
+	options.MaxAge = 2 * time.Second
+	options.IdleFlush = true
+	options.FetchBatch = 64
+	options.FetchMaxBytes = 8 << 10
+	options.FetchTimeout = 200 * time.Millisecond
+	options.AdaptiveFetch = true
+	options.Suffix = "json"
+	options.MessageDecoder = JSONDecoder[P](StaticKey("all"))
+	options.WriterFactory = func() FormattedDataWriter[P] {
+		return &NewLineDelimitedJSON[P]{}
+	}
+	options.Store = SingleDirStore[K](t.TempDir())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nc := clientConnectToServer(t, s)
+	t.Cleanup(nc.Close)
+
+	capture := options.Build()
+
+	done := make(chan error)
+	go func() {
+		done <- capture.Run(ctx, nc)
+	}()
+
+	assert.Eventually(func() bool {
+		return capture.Stats().Acked == cfg.messages
+	}, 8*time.Second, 100*time.Millisecond)
+
+	stats := capture.Stats()
+	assert.Equal(cfg.messages, stats.Fetched)
+	assert.LessOrEqual(stats.FetchBatch, options.FetchBatch)
+
+	cancel()
+	assert.Nil(<-done)
+}
+
+func TestNakBatch(t *testing.T) {
+	assert := require.New(t)
+
+	cfg := captureTestConfig{
+		messages:        6,
+		maxAckPending:   100,
+		maxRequestBatch: 50,
+		ackWait:         time.Minute,
+		startingOrderID: 1,
+	}
+
+	nc, js, _ := initJetStream(t, cfg)
+
+	c := New[string, string](Options[string, string]{})
+	c.acker = nc
+
+	sub, err := js.PullSubscribe(_EMPTY_, consumerName, nats.Bind(streamName, consumerName))
+	assert.Nil(err)
+
+	messages, err := sub.Fetch(cfg.messages, nats.MaxWait(time.Second))
+	assert.Nil(err)
+	assert.Len(messages, cfg.messages)
+
+	// as if the spool filled up after the first two messages
+	for _, m := range messages[:2] {
+		assert.Nil(m.Ack())
+	}
+	c.nakAll(messages[2:], 100*time.Millisecond)
+
+	assert.Equal(4, c.Stats().Nacked)
+
+	// the rest is redelivered well before the ack wait expires
+	var redelivered []*nats.Msg
+	for deadline := time.Now().Add(2 * time.Second); len(redelivered) < 4 && time.Now().Before(deadline); {
+		batch, _ := sub.Fetch(cfg.messages, nats.MaxWait(500*time.Millisecond))
+		redelivered = append(redelivered, batch...)
+	}
+	assert.Len(redelivered, 4)
+
+	for i, m := range redelivered {
+		md, err := m.Metadata()
+		assert.Nil(err)
+		assert.EqualValues(2, md.NumDelivered)
+		assert.Equal(messages[i+2].Subject, m.Subject)
+	}
+}
@@ -0,0 +1,141 @@
+package jetcapture
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// FilterAction tells jetcapture what to do with a decoded message
+type FilterAction int
+
+const (
+	FilterKeep  FilterAction = iota // write the message to the block of its DestKey
+	FilterDrop                      // don't write the message. it is still acked along with its block
+	FilterRoute                     // write the message to the block of the DestKey returned by the filter
+)
+
+// Filter is called for each decoded message before it is assigned to a block. The returned DestKey is only used for
+// FilterRoute.
+type Filter[P Payload, K DestKey] func(msg *nats.Msg, payload P, destKey K) (FilterAction, K)
+
+// ChainFilters applies each filter in order. The first filter that doesn't keep the message decides its fate.
+func ChainFilters[P Payload, K DestKey](filters ...Filter[P, K]) Filter[P, K] {
+	return func(msg *nats.Msg, payload P, destKey K) (FilterAction, K) {
+		for _, f := range filters {
+			if action, dk := f(msg, payload, destKey); action != FilterKeep {
+				return action, dk
+			}
+		}
+		return FilterKeep, destKey
+	}
+}
+
+// InvertFilter keeps the messages the filter drops and vice versa. Routed messages are left as is.
+func InvertFilter[P Payload, K DestKey](filter Filter[P, K]) Filter[P, K] {
+	return func(msg *nats.Msg, payload P, destKey K) (FilterAction, K) {
+		switch action, dk := filter(msg, payload, destKey); action {
+		case FilterKeep:
+			return FilterDrop, destKey
+		case FilterDrop:
+			return FilterKeep, destKey
+		default:
+			return action, dk
+		}
+	}
+}
+
+// SubjectFilter keeps messages with a subject matching any of the patterns (which may contain `*` and `>` wildcards)
+// and drops everything else. Use InvertFilter to drop matching messages instead (e.g. heartbeats).
+func SubjectFilter[P Payload, K DestKey](patterns ...string) Filter[P, K] {
+	match := subjectMatcher(patterns)
+
+	return func(msg *nats.Msg, _ P, destKey K) (FilterAction, K) {
+		if match(msg.Subject) {
+			return FilterKeep, destKey
+		}
+		return FilterDrop, destKey
+	}
+}
+
+// HeaderFilter keeps messages where the named header has any of the values, or is set at all if no values are given.
+// Everything else is dropped.
+func HeaderFilter[P Payload, K DestKey](name string, values ...string) Filter[P, K] {
+	return func(msg *nats.Msg, _ P, destKey K) (FilterAction, K) {
+		for _, v := range msg.Header.Values(name) {
+			if len(values) == 0 {
+				return FilterKeep, destKey
+			}
+			for _, want := range values {
+				if v == want {
+					return FilterKeep, destKey
+				}
+			}
+		}
+		return FilterDrop, destKey
+	}
+}
+
+// SampleFilter keeps roughly `rate` (0.0 to 1.0) of the messages. The decision is a hash of the stream name and
+// sequence so that redelivered messages are sampled the same way.
+func SampleFilter[P Payload, K DestKey](rate float64) Filter[P, K] {
+	threshold := uint64(math.Max(0, math.Min(1, rate)) * math.MaxUint64)
+
+	return func(msg *nats.Msg, _ P, destKey K) (FilterAction, K) {
+		h := fnv.New64a()
+
+		if md, err := msg.Metadata(); err == nil {
+			_, _ = h.Write([]byte(md.Stream))
+			_, _ = h.Write(binary.BigEndian.AppendUint64(nil, md.Sequence.Stream))
+		} else {
+			_, _ = h.Write([]byte(msg.Subject))
+			_, _ = h.Write(msg.Data)
+		}
+
+		if rate >= 1 || mix64(h.Sum64()) < threshold {
+			return FilterKeep, destKey
+		}
+		return FilterDrop, destKey
+	}
+}
+
+// RouteSubjects routes messages with a subject matching any of the patterns to another DestKey
+func RouteSubjects[P Payload, K DestKey](to K, patterns ...string) Filter[P, K] {
+	match := subjectMatcher(patterns)
+
+	return func(msg *nats.Msg, _ P, destKey K) (FilterAction, K) {
+		if match(msg.Subject) {
+			return FilterRoute, to
+		}
+		return FilterKeep, destKey
+	}
+}
+
+// mix64 is the splitmix64 finalizer. FNV alone mixes the high bits poorly for short, similar inputs.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func subjectMatcher(patterns []string) func(subject string) bool {
+	tokenized := make([][]string, len(patterns))
+	for i, p := range patterns {
+		tokenized[i] = strings.Split(p, ".")
+	}
+
+	return func(subject string) bool {
+		for _, p := range tokenized {
+			if _, ok := matchSubject(p, subject); ok {
+				return true
+			}
+		}
+		return false
+	}
+}
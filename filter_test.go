@@ -0,0 +1,153 @@
+package jetcapture
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilters(t *testing.T) {
+	assert := require.New(t)
+
+	msg := &nats.Msg{
+		Subject: "orders.a.created",
+		Header:  nats.Header{"Type": []string{"heartbeat"}},
+	}
+
+	keep := func(f Filter[any, string]) bool {
+		action, _ := f(msg, nil, "dk")
+		return action == FilterKeep
+	}
+
+	assert.True(keep(SubjectFilter[any, string]("orders.>")))
+	assert.True(keep(SubjectFilter[any, string]("foo", "orders.*.created")))
+	assert.False(keep(SubjectFilter[any, string]("orders.*")))
+	assert.False(keep(InvertFilter(SubjectFilter[any, string]("orders.>"))))
+
+	assert.True(keep(HeaderFilter[any, string]("Type")))
+	assert.True(keep(HeaderFilter[any, string]("Type", "data", "heartbeat")))
+	assert.False(keep(HeaderFilter[any, string]("Type", "data")))
+	assert.False(keep(HeaderFilter[any, string]("Missing")))
+
+	assert.True(keep(SampleFilter[any, string](1)))
+	assert.False(keep(SampleFilter[any, string](0)))
+
+	action, dk := ChainFilters(
+		SubjectFilter[any, string]("orders.>"),
+		RouteSubjects[any, string]("elsewhere", "orders.a.*"),
+		SubjectFilter[any, string]("never"),
+	)(msg, nil, "dk")
+	assert.Equal(FilterRoute, action)
+	assert.Equal("elsewhere", dk)
+
+	sample := SampleFilter[any, string](0.1)
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		m := &nats.Msg{Subject: "orders.a.created", Data: []byte{byte(i), byte(i >> 8)}}
+		if action, _ := sample(m, nil, "dk"); action == FilterKeep {
+			kept++
+		}
+		action2, _ := sample(m, nil, "dk")
+		action1, _ := sample(m, nil, "dk")
+		assert.Equal(action1, action2)
+	}
+	assert.InDelta(1000, kept, 200)
+}
+
+func TestCaptureFilter(t *testing.T) {
+	assert := require.New(t)
+
+	cfg := captureTestConfig{
+		messages:        1000,
+		maxAckPending:   20000,
+		maxRequestBatch: 100,
+		ackWait:         time.Minute,
+		startingOrderID: 200000,
+	}
+
+	_, _, s := initJetStream(t, cfg)
+
+	type (
+		P = map[string]any
+		K = string
+	)
+
+	options := DefaultOptions[P, K]()
+	options.NATSStreamName = streamName
+	options.NATSConsumerName = consumerName
+	options.MaxAge = 10 * time.Second
+	options.Suffix = "json"
+	options.MessageDecoder = JSONDecoder[P](JSONPointerKey("/customer_name"))
+	options.WriterFactory = func() FormattedDataWriter[P] {
+		return &NewLineDelimitedJSON[P]{}
+	}
+	options.Filter = ChainFilters(
+		InvertFilter(SubjectFilter[P, K]("orders.b.*")),
+		RouteSubjects[P, K]("routed", "orders.c.*"),
+	)
+
+	output := t.TempDir()
+
+	options.Store = &LocalFSStore[K]{
+		Resolver: func(dk K) (string, error) {
+			return filepath.Join(output, dk), nil
+		},
+	}
+
+	// blocks that only hold dropped messages are acked, but never stored
+	stored := map[K]int{}
+	options.OnStoreComplete = func(dk K, p string, _ int64, _ time.Duration, err error) {
+		assert.Nil(err)
+		assert.NotEmpty(p)
+		stored[dk]++
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	nc, err := nats.Connect(s.ClientURL())
+	assert.Nil(err)
+
+	defer nc.Close()
+
+	capture := options.Build()
+	assert.ErrorIs(capture.Run(ctx, nc), context.DeadlineExceeded)
+
+	stats := capture.Stats()
+	assert.Equal(cfg.messages, stats.Fetched)
+	assert.Equal(cfg.messages, stats.Acked)
+	assert.Greater(stats.Dropped, 0)
+	assert.Greater(stats.Routed, 0)
+	assert.Zero(stored["b"])
+	assert.Greater(stored["routed"], 0)
+
+	_, err = os.Stat(filepath.Join(output, "b"))
+	assert.True(os.IsNotExist(err))
+
+	_, err = os.Stat(filepath.Join(output, "c"))
+	assert.True(os.IsNotExist(err))
+
+	rows := 0
+	assert.Nil(filepath.WalkDir(filepath.Join(output, "routed"), func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		assert.Nil(err)
+		for _, line := range bytes.Split(bytes.TrimSpace(b), []byte("\n")) {
+			var p P
+			assert.Nil(json.Unmarshal(line, &p))
+			assert.Equal("c", p["customer_name"])
+			rows++
+		}
+		return nil
+	}))
+	assert.Equal(stats.Routed, rows)
+}
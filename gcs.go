@@ -0,0 +1,99 @@
+package jetcapture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+const gcsUploadChunkSz = 16 * 1024 * 1024
+
+var (
+	_ BlockStore[string] = &GCSBlockStore[string]{}
+)
+
+// BuildGCSLocation should return the bucket and object prefix for the block
+// For example: `backup`, `from-stream-foo/`
+type BuildGCSLocation[K DestKey] func(ctx context.Context, destKey K) (bucket, prefix string, err error)
+
+// OverrideGCSWriter is an optional function to override the object attributes (e.g. StorageClass or Metadata) and
+// upload settings of the writer before the block is uploaded
+type OverrideGCSWriter[K DestKey] func(w *storage.Writer, destKey K)
+
+// GCSBlockStore uploads blocks to Google Cloud Storage using resumable uploads
+type GCSBlockStore[K DestKey] struct {
+	client     *storage.Client
+	locationFn BuildGCSLocation[K]
+	optionsFn  OverrideGCSWriter[K]
+}
+
+// NewGCSBlockStore uses an existing client, which is where authentication and endpoints are configured (e.g.
+// `storage.NewClient(ctx, option.WithCredentialsFile(...))`)
+func NewGCSBlockStore[K DestKey](
+	client *storage.Client,
+	locationFn BuildGCSLocation[K],
+	optionsFn OverrideGCSWriter[K],
+) (*GCSBlockStore[K], error) {
+	if client == nil {
+		return nil, errors.New("client not set")
+	}
+
+	if locationFn == nil {
+		return nil, errors.New("location function not set")
+	}
+
+	return &GCSBlockStore[K]{
+		client:     client,
+		locationFn: locationFn,
+		optionsFn:  optionsFn,
+	}, nil
+}
+
+func (g *GCSBlockStore[K]) Write(ctx context.Context, block io.Reader, destKey K, dir, fileName string) (string, int64, time.Duration, error) {
+	start := time.Now()
+
+	bucket, prefix, err := g.locationFn(ctx, destKey)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	name := path.Join(prefix, dir, fileName)
+
+	log.Infof("writing block to gs://%s/%s", bucket, name)
+
+	// the writer is tied to this context. canceling it aborts the upload
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := g.client.Bucket(bucket).Object(name).NewWriter(ctx)
+	w.ChunkSize = gcsUploadChunkSz
+	w.ContentType, w.ContentEncoding = blockContentHeaders(block, fileName)
+
+	w.Metadata = map[string]string{}
+
+	if m, ok := ManifestOf(block); ok {
+		w.Metadata = m.Metadata()
+	}
+
+	if g.optionsFn != nil {
+		g.optionsFn(w, destKey)
+	}
+
+	n, err := io.Copy(w, block)
+	if err != nil {
+		cancel()
+		_ = w.Close()
+		return name, n, time.Since(start), err
+	}
+
+	if err := w.Close(); err != nil {
+		return name, n, time.Since(start), fmt.Errorf("gs://%s/%s: %w", bucket, name, err)
+	}
+
+	return name, n, time.Since(start), nil
+}
@@ -0,0 +1,208 @@
+package jetcapture
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+)
+
+type fakeGCSObject struct {
+	Bucket          string            `json:"bucket"`
+	Name            string            `json:"name"`
+	ContentType     string            `json:"contentType"`
+	ContentEncoding string            `json:"contentEncoding"`
+	StorageClass    string            `json:"storageClass"`
+	Metadata        map[string]string `json:"metadata"`
+	Size            string            `json:"size"`
+
+	data []byte
+}
+
+// fakeGCS implements the multipart and resumable upload endpoints of the GCS JSON API
+type fakeGCS struct {
+	srv     *httptest.Server
+	mu      sync.Mutex
+	objects map[string]*fakeGCSObject
+	uploads map[string]*fakeGCSObject
+	chunks  int
+}
+
+func newFakeGCS(t *testing.T) *fakeGCS {
+	f := &fakeGCS{
+		objects: map[string]*fakeGCSObject{},
+		uploads: map[string]*fakeGCSObject{},
+	}
+
+	f.srv = httptest.NewServer(f)
+	t.Cleanup(f.srv.Close)
+
+	return f
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket := strings.TrimPrefix(r.URL.Path, "/upload/storage/v1/b/")
+	bucket, _, _ = strings.Cut(bucket, "/")
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Query().Get("uploadType") == "multipart":
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mr := multipart.NewReader(r.Body, params["boundary"])
+
+		var obj fakeGCSObject
+
+		part, err := mr.NextPart()
+		if err != nil || json.NewDecoder(part).Decode(&obj) != nil {
+			http.Error(w, "bad metadata", http.StatusBadRequest)
+			return
+		}
+
+		if part, err = mr.NextPart(); err != nil {
+			http.Error(w, "missing media", http.StatusBadRequest)
+			return
+		}
+
+		obj.data, _ = io.ReadAll(part)
+		obj.Bucket = bucket
+		f.finish(w, &obj)
+
+	case r.Method == http.MethodPost && r.URL.Query().Get("uploadType") == "resumable":
+		var obj fakeGCSObject
+		if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
+			http.Error(w, "bad metadata", http.StatusBadRequest)
+			return
+		}
+
+		obj.Bucket = bucket
+
+		id := strconv.Itoa(len(f.uploads))
+		f.uploads[id] = &obj
+
+		w.Header().Set("Location", f.srv.URL+"/resumable/"+id)
+		w.WriteHeader(http.StatusOK)
+
+	case strings.HasPrefix(r.URL.Path, "/resumable/"):
+		obj, ok := f.uploads[strings.TrimPrefix(r.URL.Path, "/resumable/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		b, _ := io.ReadAll(r.Body)
+		obj.data = append(obj.data, b...)
+		f.chunks++
+
+		// `bytes 0-99/*` for intermediate chunks, `bytes 100-149/150` (or `bytes */150`) for the final one
+		if strings.HasSuffix(r.Header.Get("Content-Range"), "/*") {
+			// the client sends `X-GUploader-No-308` so incomplete uploads are signalled using a 200 and this header
+			w.Header().Set("X-Http-Status-Code-Override", "308")
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(obj.data)-1))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		f.finish(w, obj)
+
+	default:
+		http.Error(w, "unexpected request "+r.Method+" "+r.URL.String(), http.StatusBadRequest)
+	}
+}
+
+func (f *fakeGCS) finish(w http.ResponseWriter, obj *fakeGCSObject) {
+	obj.Size = strconv.Itoa(len(obj.data))
+	f.objects[obj.Bucket+"/"+obj.Name] = obj
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(obj)
+}
+
+func TestGCSBlockStore(t *testing.T) {
+	assert := require.New(t)
+
+	ctx := context.Background()
+	fake := newFakeGCS(t)
+
+	client, err := storage.NewClient(ctx, option.WithEndpoint(fake.srv.URL+"/storage/v1/"), option.WithoutAuthentication())
+	assert.Nil(err)
+
+	defer client.Close()
+
+	chunkSize := 0
+
+	store, err := NewGCSBlockStore[string](
+		client,
+		func(_ context.Context, dk string) (string, string, error) {
+			return "backup", "from-stream/" + dk, nil
+		},
+		func(w *storage.Writer, dk string) {
+			w.StorageClass = "COLDLINE"
+			w.Metadata["dest_key"] = dk
+			if chunkSize > 0 {
+				w.ChunkSize = chunkSize
+			}
+		},
+	)
+	assert.Nil(err)
+
+	block := &manifestReader{
+		ReadSeeker: bytes.NewReader([]byte("hello gcs")),
+		manifest: BlockManifest{
+			ID:          "01ARZ3NDEKTSV4RRFFQ69G5FAV",
+			RowCount:    3,
+			FirstSeq:    10,
+			LastSeq:     11,
+			Compression: GZip,
+		},
+	}
+
+	p, n, _, err := store.Write(ctx, block, "k1", "2024/01/02/03/00", "backup-1.json.gz")
+	assert.Nil(err)
+	assert.Equal("from-stream/k1/2024/01/02/03/00/backup-1.json.gz", p)
+	assert.EqualValues(9, n)
+
+	obj := fake.objects["backup/"+p]
+	assert.NotNil(obj)
+	assert.Equal("hello gcs", string(obj.data))
+	assert.Equal("application/x-ndjson", obj.ContentType)
+	assert.Equal("gzip", obj.ContentEncoding)
+	assert.Equal("COLDLINE", obj.StorageClass)
+	assert.Equal("k1", obj.Metadata["dest_key"])
+	assert.Equal("3", obj.Metadata["row_count"])
+	assert.Equal("11", obj.Metadata["last_seq"])
+
+	// force several resumable chunks
+	chunkSize = 256 * 1024
+	big := bytes.Repeat([]byte("0123456789abcdef"), 3*chunkSize/16+100)
+
+	p, n, _, err = store.Write(ctx, bytes.NewReader(big), "k2", "dir", "backup-2.csv.enc")
+	assert.Nil(err)
+	assert.EqualValues(len(big), n)
+
+	obj = fake.objects["backup/"+p]
+	assert.NotNil(obj)
+	assert.Equal(big, obj.data)
+	assert.Equal("application/octet-stream", obj.ContentType)
+	assert.Equal("", obj.ContentEncoding)
+	assert.Equal(4, fake.chunks)
+}
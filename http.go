@@ -0,0 +1,213 @@
+package jetcapture
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var (
+	_ BlockStore[string] = &HTTPBlockStore[string]{}
+)
+
+// BuildHTTPURL returns the URL a block is sent to
+type BuildHTTPURL[K DestKey] func(ctx context.Context, destKey K, dir, fileName string) (string, error)
+
+// HTTPURLTemplate builds URLs from a `text/template` with the fields `.DestKey`, `.Dir` and `.FileName`, which are
+// escaped as URL path segments (`.Dir` keeps its slashes). `.Key` is the unescaped DestKey, e.g. for the fields of a
+// struct key, and should be passed to the `pathEscape` function.
+// For example: `https://ingest.example.com/stores/{{.DestKey}}/blocks/{{.FileName}}`
+func HTTPURLTemplate[K DestKey](tmpl string) (BuildHTTPURL[K], error) {
+	t, err := template.New("url").Option("missingkey=error").Funcs(template.FuncMap{
+		"pathEscape": func(v any) string {
+			return url.PathEscape(fmt.Sprint(v))
+		},
+	}).Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(_ context.Context, destKey K, dir, fileName string) (string, error) {
+		var sb strings.Builder
+
+		segments := strings.Split(dir, "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+
+		err := t.Execute(&sb, struct {
+			DestKey  string
+			Dir      string
+			FileName string
+			Key      K
+		}{url.PathEscape(fmt.Sprint(destKey)), strings.Join(segments, "/"), url.PathEscape(fileName), destKey})
+
+		return sb.String(), err
+	}, nil
+}
+
+// HTTPBlockStore sends each block as the (streamed) body of an HTTP request, e.g. to an internal ingestion service.
+// 2xx responses are a success, 429 and 5xx responses are marked as Retryable (see RetryingStore), and everything else
+// is Permanent.
+type HTTPBlockStore[K DestKey] struct {
+	URL         BuildHTTPURL[K]
+	Method      string                                    // defaults to POST
+	Client      *http.Client                              // defaults to `http.DefaultClient`. see NewMTLSClient for mTLS
+	BearerToken func(ctx context.Context) (string, error) // optional, sent as `Authorization: Bearer <token>`
+	Header      http.Header                               // optional additional headers
+}
+
+func NewHTTPBlockStore[K DestKey](url BuildHTTPURL[K]) *HTTPBlockStore[K] {
+	return &HTTPBlockStore[K]{
+		URL: url,
+	}
+}
+
+// NewMTLSClient returns an HTTP client authenticating with a client certificate. The CA file is optional and replaces
+// the system roots for verifying the server.
+func NewMTLSClient(certFile, keyFile, caFile string) (*http.Client, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if caFile != _EMPTY_ {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+
+	return &http.Client{Transport: transport}, nil
+}
+
+func (h *HTTPBlockStore[K]) Write(ctx context.Context, block io.Reader, destKey K, dir, fileName string) (string, int64, time.Duration, error) {
+	start := time.Now()
+
+	if h.URL == nil {
+		return "", 0, 0, errors.New("URL not set")
+	}
+
+	u, err := h.URL(ctx, destKey, dir, fileName)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	method := h.Method
+	if method == _EMPTY_ {
+		method = http.MethodPost
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	rs, err := rewindable(block)
+	if err != nil {
+		return u, 0, 0, err
+	}
+
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return u, 0, 0, err
+	}
+
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return u, 0, 0, err
+	}
+
+	reader := &countingReader{Reader: rs}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, io.NopCloser(reader))
+	if err != nil {
+		return u, 0, 0, err
+	}
+
+	// a known length avoids chunked encoding, and GetBody lets the client resend the block on redirects
+	req.ContentLength = size
+	req.GetBody = func() (io.ReadCloser, error) {
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		reader.n = 0
+		return io.NopCloser(reader), nil
+	}
+
+	for k, v := range h.Header {
+		req.Header[k] = v
+	}
+
+	contentType, contentEncoding := blockContentHeaders(block, fileName)
+
+	req.Header.Set("Content-Type", contentType)
+	if contentEncoding != _EMPTY_ {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+
+	// the block id is unique per block, so receivers can safely dedupe retried requests
+	idempotencyKey := fileName
+	if m, ok := ManifestOf(block); ok {
+		idempotencyKey = m.ID
+		for k, v := range m.Metadata() {
+			req.Header.Set("X-Jetcapture-"+strings.ReplaceAll(k, "_", "-"), v)
+		}
+	}
+
+	req.Header.Set("Idempotency-Key", idempotencyKey)
+
+	if h.BearerToken != nil {
+		token, err := h.BearerToken(ctx)
+		if err != nil {
+			return u, 0, 0, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	log.Debugf("sending block to %s %s", method, u)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return u, int64(reader.n), time.Since(start), err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return u, int64(reader.n), time.Since(start), nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	err = fmt.Errorf("%s %s: %s: %s", method, u, resp.Status, bytes.TrimSpace(body))
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return u, int64(reader.n), time.Since(start), Retryable(err)
+	}
+
+	return u, int64(reader.n), time.Since(start), Permanent(err)
+}
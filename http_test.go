@@ -0,0 +1,198 @@
+package jetcapture
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPBlockStore(t *testing.T) {
+	assert := require.New(t)
+
+	var (
+		status   = http.StatusCreated
+		requests []*http.Request
+		bodies   []string
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("nope"))
+	}))
+	t.Cleanup(srv.Close)
+
+	url, err := HTTPURLTemplate[string](srv.URL + "/ingest/{{.DestKey}}/{{.Dir}}{{.FileName}}")
+	assert.Nil(err)
+
+	store := NewHTTPBlockStore(url)
+	store.BearerToken = func(context.Context) (string, error) { return "t0ken", nil }
+
+	block := &manifestReader{
+		ReadSeeker: bytes.NewReader([]byte("{\"a\":1}\n")),
+		manifest: BlockManifest{
+			ID:           "01ARZ3NDEKTSV4RRFFQ69G5FAV",
+			MessageCount: 1,
+			RowCount:     1,
+			Compression:  GZip,
+		},
+	}
+
+	p, n, _, err := store.Write(context.Background(), block, "k1", "2024/01/02/03/00/", "backup-1.json.gz")
+	assert.Nil(err)
+	assert.Equal(srv.URL+"/ingest/k1/2024/01/02/03/00/backup-1.json.gz", p)
+	assert.EqualValues(8, n)
+
+	r := requests[0]
+	assert.Equal(http.MethodPost, r.Method)
+	assert.Equal("{\"a\":1}\n", bodies[0])
+	assert.Equal("Bearer t0ken", r.Header.Get("Authorization"))
+	assert.Equal("application/x-ndjson", r.Header.Get("Content-Type"))
+	assert.Equal("gzip", r.Header.Get("Content-Encoding"))
+	assert.Equal("01ARZ3NDEKTSV4RRFFQ69G5FAV", r.Header.Get("Idempotency-Key"))
+	assert.Equal("1", r.Header.Get("X-Jetcapture-Row-Count"))
+
+	for code, retryable := range map[int]bool{
+		http.StatusTooManyRequests:     true,
+		http.StatusServiceUnavailable:  true,
+		http.StatusBadRequest:          false,
+		http.StatusForbidden:           false,
+		http.StatusMovedPermanently:    false,
+		http.StatusInternalServerError: true,
+	} {
+		status = code
+		_, _, _, err = store.Write(context.Background(), strings.NewReader("x"), "k1", "dir/", "file")
+		assert.NotNil(err, code)
+		assert.Contains(err.Error(), "nope")
+		assert.Equal(retryable, IsRetryable(err), code)
+	}
+
+	// without a manifest the file name is used as the idempotency key
+	assert.Equal("file", requests[len(requests)-1].Header.Get("Idempotency-Key"))
+
+	// keys are escaped
+	status = http.StatusOK
+	p, _, _, err = store.Write(context.Background(), strings.NewReader("x"), "a/b?c#d e", "2024/01/", "backup-1.json")
+	assert.Nil(err)
+	assert.Equal(srv.URL+"/ingest/a%2Fb%3Fc%23d%20e/2024/01/backup-1.json", p)
+	assert.Equal("/ingest/a%2Fb%3Fc%23d%20e/2024/01/backup-1.json", requests[len(requests)-1].URL.EscapedPath())
+	assert.EqualValues(1, requests[len(requests)-1].ContentLength)
+
+	type structKey struct{ Tenant string }
+
+	structURL, err := HTTPURLTemplate[structKey](srv.URL + "/{{.Key.Tenant | pathEscape}}/{{.FileName}}")
+	assert.Nil(err)
+
+	u, err := structURL(context.Background(), structKey{Tenant: "a b"}, "dir/", "file")
+	assert.Nil(err)
+	assert.Equal(srv.URL+"/a%20b/file", u)
+}
+
+func TestHTTPBlockStoreRedirect(t *testing.T) {
+	assert := require.New(t)
+
+	var bodies []string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		http.Redirect(w, r, "/new", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	store := NewHTTPBlockStore[string](func(context.Context, string, string, string) (string, error) {
+		return srv.URL + "/old", nil
+	})
+
+	// a non-seekable block is buffered, so it can still be sent again
+	_, n, _, err := store.Write(context.Background(), io.MultiReader(strings.NewReader("resent")), "k", "dir/", "file")
+	assert.Nil(err)
+	assert.EqualValues(6, n)
+	assert.Equal([]string{"resent"}, bodies)
+}
+
+func TestHTTPBlockStoreMTLS(t *testing.T) {
+	assert := require.New(t)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	dir := t.TempDir()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(err)
+
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "jetcapture"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &x509.Certificate{SerialNumber: big.NewInt(1)}, &key.PublicKey, key)
+	assert.Nil(err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(err)
+
+	writePEM := func(name, typ string, b []byte) string {
+		p := filepath.Join(dir, name)
+		assert.Nil(os.WriteFile(p, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b}), 0600))
+		return p
+	}
+
+	certFile := writePEM("client.crt", "CERTIFICATE", der)
+	keyFile := writePEM("client.key", "EC PRIVATE KEY", keyDER)
+	caFile := writePEM("ca.crt", "CERTIFICATE", srv.Certificate().Raw)
+
+	client, err := NewMTLSClient(certFile, keyFile, caFile)
+	assert.Nil(err)
+
+	url, err := HTTPURLTemplate[string](srv.URL + "/{{.FileName}}")
+	assert.Nil(err)
+
+	store := NewHTTPBlockStore(url)
+	store.Client = client
+
+	_, _, _, err = store.Write(context.Background(), strings.NewReader("secure"), "k", "dir/", "file")
+	assert.Nil(err)
+
+	// the same server rejects clients without a certificate
+	store.Client = srv.Client()
+	_, _, _, err = store.Write(context.Background(), strings.NewReader("secure"), "k", "dir/", "file")
+	assert.NotNil(err)
+}
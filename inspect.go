@@ -0,0 +1,113 @@
+package jetcapture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MessageQuery selects captured NatsMessage records, e.g. when inspecting blocks. Zero values match everything.
+type MessageQuery struct {
+	Subjects       []string          // subject patterns (wildcards are supported). messages need to match any of them
+	Headers        map[string]string // headers that need to be set to the value, or set at all if the value is empty
+	From, To       time.Time         // message timestamp within [From, To)
+	MinSeq, MaxSeq uint64            // stream sequence within [MinSeq, MaxSeq]
+}
+
+// Matcher compiles the query into a predicate. Messages without metadata never match time or sequence conditions.
+func (q MessageQuery) Matcher() func(m *NatsMessage) bool {
+	subjectMatch := subjectMatcher(q.Subjects)
+
+	return func(m *NatsMessage) bool {
+		if len(q.Subjects) > 0 && !subjectMatch(m.Subject) {
+			return false
+		}
+
+		for name, want := range q.Headers {
+			values, ok := headerValues(m.Header, name)
+			if !ok {
+				return false
+			}
+
+			if want != _EMPTY_ && !slices.Contains(values, want) {
+				return false
+			}
+		}
+
+		if q.From.IsZero() && q.To.IsZero() && q.MinSeq == 0 && q.MaxSeq == 0 {
+			return true
+		}
+
+		md := m.Metadata
+		if md == nil {
+			return false
+		}
+
+		if !q.From.IsZero() && md.Timestamp.Before(q.From) {
+			return false
+		}
+
+		if !q.To.IsZero() && !md.Timestamp.Before(q.To) {
+			return false
+		}
+
+		if q.MinSeq > 0 && md.Sequence.Stream < q.MinSeq {
+			return false
+		}
+
+		if q.MaxSeq > 0 && md.Sequence.Stream > q.MaxSeq {
+			return false
+		}
+
+		return true
+	}
+}
+
+// headerValues looks up a header case-insensitively since captured headers keep whatever case they were sent with
+func headerValues(header map[string][]string, name string) ([]string, bool) {
+	if v, ok := header[name]; ok {
+		return v, true
+	}
+
+	for k, v := range header {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+
+	return nil, false
+}
+
+// ReadNatsMessages decodes the plain contents of a block written with `NatsToNats` and `NewLineDelimitedJSON` (see
+// OpenBlock), calling fn for each message
+func ReadNatsMessages(r io.Reader, fn func(m *NatsMessage) error) error {
+	in := bufio.NewReader(r)
+
+	for line := 1; ; line++ {
+		b, err := in.ReadBytes('\n')
+
+		if len(bytes.TrimSpace(b)) > 0 {
+			var m NatsMessage
+			if err := json.Unmarshal(b, &m); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+
+			if err := fn(&m); err != nil {
+				return err
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
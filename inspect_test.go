@@ -0,0 +1,68 @@
+package jetcapture
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadNatsMessages(t *testing.T) {
+	assert := require.New(t)
+
+	ts := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+
+	w := &NewLineDelimitedJSON[*NatsMessage]{}
+	assert.Nil(w.InitNew(&buf))
+
+	for i := 1; i <= 4; i++ {
+		m := &NatsMessage{
+			Subject: []string{"orders.eu.new", "orders.us.new"}[i%2],
+			Header:  map[string][]string{"Region": {[]string{"eu", "us"}[i%2]}},
+			Data:    []byte(`{"id":` + string(rune('0'+i)) + `}`),
+			Metadata: &nats.MsgMetadata{
+				Sequence:  nats.SequencePair{Stream: uint64(10 + i)},
+				Timestamp: ts.Add(time.Duration(i) * time.Minute),
+			},
+		}
+		_, err := w.Write(m)
+		assert.Nil(err)
+	}
+
+	// messages without metadata only match queries without time or sequence conditions
+	_, err := w.Write(&NatsMessage{Subject: "orders.eu.old"})
+	assert.Nil(err)
+
+	read := func(q MessageQuery) (seqs []uint64) {
+		match := q.Matcher()
+		assert.Nil(ReadNatsMessages(bytes.NewReader(buf.Bytes()), func(m *NatsMessage) error {
+			if match(m) {
+				var seq uint64
+				if m.Metadata != nil {
+					seq = m.Metadata.Sequence.Stream
+				}
+				seqs = append(seqs, seq)
+			}
+			return nil
+		}))
+		return
+	}
+
+	assert.Equal([]uint64{11, 12, 13, 14, 0}, read(MessageQuery{}))
+	assert.Equal([]uint64{12, 14, 0}, read(MessageQuery{Subjects: []string{"orders.eu.*"}}))
+	assert.Equal([]uint64{11, 13}, read(MessageQuery{Headers: map[string]string{"region": "us"}}))
+	assert.Equal([]uint64{11, 12, 13, 14}, read(MessageQuery{Headers: map[string]string{"Region": ""}}))
+	assert.Equal([]uint64{12, 13}, read(MessageQuery{From: ts.Add(2 * time.Minute), To: ts.Add(4 * time.Minute)}))
+	assert.Equal([]uint64{13, 14}, read(MessageQuery{MinSeq: 13}))
+	assert.Equal([]uint64{12}, read(MessageQuery{Subjects: []string{"orders.>"}, MinSeq: 12, MaxSeq: 12}))
+
+	err = ReadNatsMessages(bytes.NewReader([]byte("{}\nnope\n")), func(*NatsMessage) error { return nil })
+	var syntaxErr *json.SyntaxError
+	assert.ErrorAs(err, &syntaxErr)
+	assert.ErrorContains(err, "line 2")
+}
@@ -0,0 +1,428 @@
+package jetcapture
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// ErrKeyNotFound is returned by a KeyResolver when the message does not contain the requested key
+var ErrKeyNotFound = errors.New("destination key not found")
+
+// KeyResolver resolves a DestKey from a raw NATS message
+type KeyResolver[K DestKey] func(msg *nats.Msg) (K, error)
+
+// StaticKey always resolves to the same key
+func StaticKey[K DestKey](key K) KeyResolver[K] {
+	return func(*nats.Msg) (K, error) {
+		return key, nil
+	}
+}
+
+// SubjectTokenKey resolves to the subject token at the (zero based) index.
+// For example, index 1 of `orders.store42.created` is `store42`
+func SubjectTokenKey(index int) KeyResolver[string] {
+	return func(msg *nats.Msg) (string, error) {
+		tokens := strings.Split(msg.Subject, ".")
+		if index < 0 || index >= len(tokens) {
+			return _EMPTY_, fmt.Errorf("%w: subject %q has no token at index %d", ErrKeyNotFound, msg.Subject, index)
+		}
+		return tokens[index], nil
+	}
+}
+
+// HeaderKey resolves to the first value of the named message header
+func HeaderKey(name string) KeyResolver[string] {
+	return func(msg *nats.Msg) (string, error) {
+		if v := msg.Header.Get(name); v != _EMPTY_ {
+			return v, nil
+		}
+		return _EMPTY_, fmt.Errorf("%w: header %q not set", ErrKeyNotFound, name)
+	}
+}
+
+// JSONPointerKey resolves to the scalar value found within the JSON message payload at the RFC 6901 JSON pointer
+// (e.g. `/order/store_id`). A dotted path (e.g. `order.store_id`) is also accepted. Numbers are returned exactly as they
+// appear in the payload.
+func JSONPointerKey(pointer string) KeyResolver[string] {
+	tokens := parseJSONPointer(pointer)
+
+	return func(msg *nats.Msg) (string, error) {
+		dec := json.NewDecoder(bytes.NewReader(msg.Data))
+		dec.UseNumber()
+
+		var doc any
+		if err := dec.Decode(&doc); err != nil {
+			return _EMPTY_, err
+		}
+
+		v, ok := lookupJSONPointer(doc, tokens)
+		if !ok || v == nil {
+			return _EMPTY_, fmt.Errorf("%w: no value at %q", ErrKeyNotFound, pointer)
+		}
+
+		switch t := v.(type) {
+		case string:
+			return t, nil
+		case json.Number:
+			return t.String(), nil
+		case bool:
+			return strconv.FormatBool(t), nil
+		default:
+			return _EMPTY_, fmt.Errorf("value at %q is not a scalar", pointer)
+		}
+	}
+}
+
+// parseJSONPointer splits either an RFC 6901 pointer or a dotted path into its reference tokens
+func parseJSONPointer(pointer string) []string {
+	if pointer == _EMPTY_ {
+		return nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return strings.Split(pointer, ".")
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+
+	return tokens
+}
+
+// lookupJSONPointer walks a generically decoded JSON document
+func lookupJSONPointer(doc any, tokens []string) (any, bool) {
+	for _, t := range tokens {
+		switch node := doc.(type) {
+		case map[string]any:
+			v, ok := node[t]
+			if !ok {
+				return nil, false
+			}
+			doc = v
+		case []any:
+			i, err := strconv.Atoi(t)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			doc = node[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return doc, true
+}
+
+// OrDefault adapts the resolver for decoders that cannot fail key resolution (e.g. NatsToNats). The default key is used
+// whenever the resolver returns an error.
+func (r KeyResolver[K]) OrDefault(def K) func(msg *nats.Msg) K {
+	return func(msg *nats.Msg) K {
+		if dk, err := r(msg); err == nil {
+			return dk
+		}
+		return def
+	}
+}
+
+// FirstKey tries each resolver in order and returns the first key that resolves without an error
+func FirstKey[K DestKey](resolvers ...KeyResolver[K]) KeyResolver[K] {
+	return func(msg *nats.Msg) (K, error) {
+		var (
+			dk  K
+			err = fmt.Errorf("%w: no resolvers", ErrKeyNotFound)
+		)
+
+		for _, r := range resolvers {
+			if dk, err = r(msg); err == nil {
+				return dk, nil
+			}
+		}
+
+		return dk, err
+	}
+}
+
+// HeaderKeyOr resolves to the first value of the named message header, or to the fallback resolver if it is not set
+func HeaderKeyOr(name string, fallback KeyResolver[string]) KeyResolver[string] {
+	return FirstKey(HeaderKey(name), fallback)
+}
+
+// MaxKeyParts is the maximum number of parts in a MultiPartKey
+const MaxKeyParts = 8
+
+// MultiPartKey is a comparable DestKey made up of several resolved string parts (e.g. region and customer)
+type MultiPartKey struct {
+	parts [MaxKeyParts]string
+	n     int
+}
+
+// NewMultiPartKey creates a key from up to MaxKeyParts parts
+func NewMultiPartKey(parts ...string) MultiPartKey {
+	if len(parts) > MaxKeyParts {
+		panic(fmt.Sprintf("a MultiPartKey supports at most %d parts", MaxKeyParts))
+	}
+
+	var k MultiPartKey
+	k.n = copy(k.parts[:], parts)
+	return k
+}
+
+// Parts returns the individual key parts
+func (k MultiPartKey) Parts() []string {
+	return append([]string(nil), k.parts[:k.n]...)
+}
+
+// Path joins the key parts using `/`, which is convenient for `LocalFSStore.Resolver` and similar
+func (k MultiPartKey) Path() string {
+	return strings.Join(k.parts[:k.n], "/")
+}
+
+func (k MultiPartKey) String() string {
+	return k.Path()
+}
+
+// MultiPart combines several resolvers into a single MultiPartKey. Resolution fails if any of the parts fail.
+func MultiPart(resolvers ...KeyResolver[string]) KeyResolver[MultiPartKey] {
+	if len(resolvers) > MaxKeyParts {
+		panic(fmt.Sprintf("a MultiPartKey supports at most %d parts", MaxKeyParts))
+	}
+
+	return func(msg *nats.Msg) (MultiPartKey, error) {
+		var k MultiPartKey
+
+		for i, r := range resolvers {
+			part, err := r(msg)
+			if err != nil {
+				return MultiPartKey{}, err
+			}
+			k.parts[i] = part
+		}
+
+		k.n = len(resolvers)
+
+		return k, nil
+	}
+}
+
+// SubjectMappingKey works like NATS subject mapping. The subject is matched against the source pattern (which may
+// contain `*` and `>` wildcards) and the key is rendered from the destination template, where `{{wildcard(n)}}`
+// refers to the n-th (one based) wildcard of the source. All KeyExpression functions may be used as well.
+//
+// For example, source `orders.*.*` and destination `{{wildcard(1)}}` maps `orders.store42.created` to `store42`.
+// Subjects that don't match the source pattern fail with ErrKeyNotFound.
+func SubjectMappingKey(source, destination string) (KeyResolver[string], error) {
+	pattern := strings.Split(source, ".")
+
+	wildcards := 0
+
+	for i, t := range pattern {
+		switch t {
+		case "*":
+			wildcards++
+		case ">":
+			if i != len(pattern)-1 {
+				return nil, fmt.Errorf("invalid source %q: `>` must be the last token", source)
+			}
+			wildcards++
+		}
+	}
+
+	tmpl, err := parseKeyTemplate(destination, wildcards)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(msg *nats.Msg) (string, error) {
+		matched, ok := matchSubject(pattern, msg.Subject)
+		if !ok {
+			return _EMPTY_, fmt.Errorf("%w: subject %q does not match %q", ErrKeyNotFound, msg.Subject, source)
+		}
+		return tmpl.render(msg, matched)
+	}, nil
+}
+
+// KeyExpression parses a key template made up of literal text and `{{function}}` placeholders. Supported functions:
+//
+//	{{subject}}                the full subject
+//	{{token(i)}}               the (zero based) subject token, negative indexes count from the end
+//	{{header(name)}}           the first value of the header
+//	{{header(name, default)}}  the first value of the header, or the default if it is not set
+//
+// For example `{{token(1)}}/{{header(Region, unknown)}}`
+func KeyExpression(expr string) (KeyResolver[string], error) {
+	tmpl, err := parseKeyTemplate(expr, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(msg *nats.Msg) (string, error) {
+		return tmpl.render(msg, nil)
+	}, nil
+}
+
+// matchSubject returns the tokens captured by each wildcard in the pattern
+func matchSubject(pattern []string, subject string) ([]string, bool) {
+	tokens := strings.Split(subject, ".")
+
+	var matched []string
+
+	for i, p := range pattern {
+		if p == ">" {
+			if i >= len(tokens) {
+				return nil, false
+			}
+			return append(matched, strings.Join(tokens[i:], ".")), true
+		}
+
+		if i >= len(tokens) {
+			return nil, false
+		}
+
+		switch p {
+		case "*":
+			matched = append(matched, tokens[i])
+		case tokens[i]:
+		default:
+			return nil, false
+		}
+	}
+
+	return matched, len(pattern) == len(tokens)
+}
+
+type keyTemplatePart struct {
+	literal string
+	fn      string
+	args    []string
+	index   int
+}
+
+type keyTemplate []keyTemplatePart
+
+func parseKeyTemplate(expr string, wildcards int) (keyTemplate, error) {
+	var tmpl keyTemplate
+
+	for expr != _EMPTY_ {
+		before, rest, found := strings.Cut(expr, "{{")
+		if before != _EMPTY_ {
+			tmpl = append(tmpl, keyTemplatePart{literal: before})
+		}
+
+		if !found {
+			break
+		}
+
+		inner, after, found := strings.Cut(rest, "}}")
+		if !found {
+			return nil, fmt.Errorf("unterminated placeholder in %q", expr)
+		}
+
+		part, err := parseKeyFunction(strings.TrimSpace(inner), wildcards)
+		if err != nil {
+			return nil, err
+		}
+
+		tmpl = append(tmpl, part)
+		expr = after
+	}
+
+	if len(tmpl) == 0 {
+		return nil, errors.New("empty key expression")
+	}
+
+	return tmpl, nil
+}
+
+func parseKeyFunction(s string, wildcards int) (keyTemplatePart, error) {
+	part := keyTemplatePart{fn: s}
+
+	if name, args, found := strings.Cut(s, "("); found {
+		if !strings.HasSuffix(args, ")") {
+			return part, fmt.Errorf("invalid function %q", s)
+		}
+
+		part.fn = strings.TrimSpace(name)
+
+		for _, a := range strings.Split(strings.TrimSuffix(args, ")"), ",") {
+			part.args = append(part.args, strings.TrimSpace(a))
+		}
+	}
+
+	var err error
+
+	switch part.fn {
+	case "subject":
+		if len(part.args) != 0 {
+			return part, errors.New("subject takes no arguments")
+		}
+	case "token":
+		if len(part.args) != 1 {
+			return part, errors.New("token takes exactly one argument")
+		}
+		if part.index, err = strconv.Atoi(part.args[0]); err != nil {
+			return part, fmt.Errorf("invalid token index: %w", err)
+		}
+	case "wildcard":
+		if len(part.args) != 1 {
+			return part, errors.New("wildcard takes exactly one argument")
+		}
+		if part.index, err = strconv.Atoi(part.args[0]); err != nil {
+			return part, fmt.Errorf("invalid wildcard index: %w", err)
+		}
+		if part.index < 1 || part.index > wildcards {
+			return part, fmt.Errorf("wildcard(%d) is out of range, source has %d wildcard(s)", part.index, wildcards)
+		}
+	case "header":
+		if len(part.args) < 1 || len(part.args) > 2 || part.args[0] == _EMPTY_ {
+			return part, errors.New("header takes a name and an optional default")
+		}
+	default:
+		return part, fmt.Errorf("unknown function %q", part.fn)
+	}
+
+	return part, nil
+}
+
+func (t keyTemplate) render(msg *nats.Msg, wildcards []string) (string, error) {
+	var sb strings.Builder
+
+	for _, part := range t {
+		switch part.fn {
+		case _EMPTY_:
+			sb.WriteString(part.literal)
+		case "subject":
+			sb.WriteString(msg.Subject)
+		case "token":
+			tokens := strings.Split(msg.Subject, ".")
+			i := part.index
+			if i < 0 {
+				i += len(tokens)
+			}
+			if i < 0 || i >= len(tokens) {
+				return _EMPTY_, fmt.Errorf("%w: subject %q has no token at index %d", ErrKeyNotFound, msg.Subject, part.index)
+			}
+			sb.WriteString(tokens[i])
+		case "wildcard":
+			sb.WriteString(wildcards[part.index-1])
+		case "header":
+			v := msg.Header.Get(part.args[0])
+			if v == _EMPTY_ {
+				if len(part.args) < 2 {
+					return _EMPTY_, fmt.Errorf("%w: header %q not set", ErrKeyNotFound, part.args[0])
+				}
+				v = part.args[1]
+			}
+			sb.WriteString(v)
+		}
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,108 @@
+package jetcapture
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubjectMappingKey(t *testing.T) {
+	assert := require.New(t)
+
+	msg := &nats.Msg{Subject: "orders.store42.created.eu"}
+
+	r, err := SubjectMappingKey("orders.*.>", "{{wildcard(1)}}/{{wildcard(2)}}")
+	assert.Nil(err)
+
+	dk, err := r(msg)
+	assert.Nil(err)
+	assert.Equal("store42/created.eu", dk)
+
+	r, err = SubjectMappingKey("orders.*.*", "{{wildcard(2)}}")
+	assert.Nil(err)
+
+	_, err = r(msg)
+	assert.True(errors.Is(err, ErrKeyNotFound))
+
+	_, err = SubjectMappingKey("orders.*", "{{wildcard(2)}}")
+	assert.NotNil(err)
+
+	_, err = SubjectMappingKey("orders.>.foo", "{{wildcard(1)}}")
+	assert.NotNil(err)
+}
+
+func TestKeyExpression(t *testing.T) {
+	assert := require.New(t)
+
+	msg := &nats.Msg{
+		Subject: "orders.store42.created",
+		Header:  nats.Header{"Region": []string{"eu"}},
+	}
+
+	for expr, expected := range map[string]string{
+		"{{subject}}":                           "orders.store42.created",
+		"{{ token(1) }}":                        "store42",
+		"{{token(-1)}}":                         "created",
+		"static":                                "static",
+		"r={{header(Region)}}/{{token(0)}}":     "r=eu/orders",
+		"{{header(Tenant, none)}}/{{token(1)}}": "none/store42",
+	} {
+		r, err := KeyExpression(expr)
+		assert.Nil(err, expr)
+
+		dk, err := r(msg)
+		assert.Nil(err, expr)
+		assert.Equal(expected, dk, expr)
+	}
+
+	for _, expr := range []string{"", "{{subject", "{{nope}}", "{{token(x)}}", "{{wildcard(1)}}", "{{header()}}"} {
+		_, err := KeyExpression(expr)
+		assert.NotNil(err, expr)
+	}
+
+	r, err := KeyExpression("{{header(Tenant)}}")
+	assert.Nil(err)
+	assert.Equal("fallback", r.OrDefault("fallback")(msg))
+}
+
+func TestComposedKeys(t *testing.T) {
+	assert := require.New(t)
+
+	msg := &nats.Msg{
+		Subject: "orders.store42.created",
+		Header:  nats.Header{"Region": []string{"eu"}},
+	}
+
+	dk, err := HeaderKeyOr("Tenant", SubjectTokenKey(1))(msg)
+	assert.Nil(err)
+	assert.Equal("store42", dk)
+
+	_, err = FirstKey[string]()(msg)
+	assert.True(errors.Is(err, ErrKeyNotFound))
+
+	mk, err := MultiPart(HeaderKey("Region"), SubjectTokenKey(1))(msg)
+	assert.Nil(err)
+	assert.Equal(NewMultiPartKey("eu", "store42"), mk)
+	assert.Equal([]string{"eu", "store42"}, mk.Parts())
+	assert.Equal("eu/store42", mk.Path())
+
+	_, err = MultiPart(HeaderKey("Tenant"), SubjectTokenKey(1))(msg)
+	assert.NotNil(err)
+
+	// Metadata needs a JetStream reply subject and a subscription
+	msg.Reply = "$JS.ACK.orders.capture.1.7.7.1704164400000000000.0"
+	msg.Sub = &nats.Subscription{}
+
+	decode := NatsToNats(MultiPart(HeaderKey("Tenant")).OrDefault(NewMultiPartKey("unknown")))
+	nm, mk, err := decode(msg)
+	assert.Nil(err)
+	assert.Equal(NewMultiPartKey("unknown"), mk)
+	assert.Equal(uint64(7), nm.Metadata.Sequence.Stream)
+
+	decode = NatsToNats(MultiPart(HeaderKey("Region"), SubjectTokenKey(1)).OrDefault(NewMultiPartKey("unknown")))
+	_, mk, err = decode(msg)
+	assert.Nil(err)
+	assert.Equal("eu/store42", mk.Path())
+}
@@ -0,0 +1,137 @@
+package jetcapture
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+var (
+	_ Catalog = &KVCatalog{}
+)
+
+// KVCatalog keeps the catalog in a JetStream key/value bucket, which can be shared by all capture instances. Entries
+// are keyed by destination key and block id.
+type KVCatalog struct {
+	kv nats.KeyValue
+}
+
+// NewKVCatalog uses the named bucket, creating it if it doesn't exist
+func NewKVCatalog(js nats.JetStreamContext, bucket string) (*KVCatalog, error) {
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		log.Infof("creating catalog bucket %s", bucket)
+
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:      bucket,
+			Description: "jetcapture block catalog",
+		})
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("catalog bucket %s: %w", bucket, err)
+	}
+
+	return &KVCatalog{kv: kv}, nil
+}
+
+// kvDestKeyToken encodes a destination key into a single key token. the prefix keeps empty keys valid
+func kvDestKeyToken(destKey string) string {
+	return "k" + base64.RawURLEncoding.EncodeToString([]byte(destKey))
+}
+
+func (k *KVCatalog) Add(_ context.Context, entry CatalogEntry) error {
+	v, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = k.kv.Put(kvDestKeyToken(entry.DestKey)+"."+entry.ID, v)
+	return err
+}
+
+func (k *KVCatalog) Remove(ctx context.Context, id string) error {
+	// the id is unique, but the destination key token of its entry isn't known
+	w, err := k.kv.Watch("*."+id, nats.IgnoreDeletes(), nats.MetaOnly(), nats.Context(ctx))
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+
+	for update := range w.Updates() {
+		if update == nil {
+			break
+		}
+		keys = append(keys, update.Key())
+	}
+
+	if err := w.Stop(); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := k.kv.Delete(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (k *KVCatalog) Query(ctx context.Context, query CatalogQuery) ([]CatalogEntry, error) {
+	patterns := []string{">"}
+
+	if len(query.DestKeys) > 0 {
+		patterns = patterns[:0]
+		for _, dk := range query.DestKeys {
+			patterns = append(patterns, kvDestKeyToken(dk)+".*")
+		}
+	}
+
+	var entries []CatalogEntry
+
+	for _, pattern := range patterns {
+		w, err := k.kv.Watch(pattern, nats.IgnoreDeletes(), nats.Context(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		for update := range w.Updates() {
+			// a nil entry marks the end of the current values
+			if update == nil {
+				break
+			}
+
+			var e CatalogEntry
+			if err := json.Unmarshal(update.Value(), &e); err != nil {
+				_ = w.Stop()
+				return nil, fmt.Errorf("catalog entry %s: %w", update.Key(), err)
+			}
+
+			if query.Matches(e) {
+				entries = append(entries, e)
+			}
+		}
+
+		if err := w.Stop(); err != nil {
+			return nil, err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	sortCatalogEntries(entries)
+
+	return entries, nil
+}
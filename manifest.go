@@ -0,0 +1,121 @@
+package jetcapture
+
+import (
+	"io"
+	"strconv"
+	"time"
+)
+
+// BlockManifest describes a finalized block. Stores can use ManifestOf to retrieve it (e.g. to attach it as metadata)
+type BlockManifest struct {
+	ID            string      // unique block id (ULID)
+	Start         time.Time   // start of the block window
+	End           time.Time   // end of the block window (exclusive)
+	OldestMessage time.Time   // timestamp of the oldest message in the block (before Start for late blocks)
+	NewestMessage time.Time   // timestamp of the newest message in the block
+	MessageCount  int         // number of messages written to the block
+	RowCount      int         // number of rows written by the FormattedDataWriter
+	FirstSeq      uint64      // lowest stream sequence written to the block
+	LastSeq       uint64      // highest stream sequence written to the block
+	Compression   Compression // compression applied to the block
+	Encrypted     bool        // whether the block is encrypted using `Options.Encryption`
+	Late          bool        // whether the block holds messages for intervals that already expired
+}
+
+type manifester interface {
+	Manifest() BlockManifest
+}
+
+// ManifestOf returns the manifest of a block passed to `BlockStore.Write`. It returns false if the reader doesn't
+// carry a manifest (e.g. when a store is called directly).
+func ManifestOf(block io.Reader) (BlockManifest, bool) {
+	if m, ok := block.(manifester); ok {
+		return m.Manifest(), true
+	}
+	return BlockManifest{}, false
+}
+
+// ContentEncoding returns the HTTP `Content-Encoding` of the block, or an empty string if the block is encrypted or
+// not compressed
+func (m BlockManifest) ContentEncoding() string {
+	if m.Encrypted {
+		return _EMPTY_
+	}
+
+	switch m.Compression {
+	case GZip:
+		return "gzip"
+	case Snappy:
+		return "x-snappy-framed"
+	default:
+		return _EMPTY_
+	}
+}
+
+// Metadata returns the manifest as flat key/value pairs suitable for object metadata. The keys only use lowercase
+// letters and underscores so they are valid for all supported stores.
+func (m BlockManifest) Metadata() map[string]string {
+	md := map[string]string{
+		"block_id":      m.ID,
+		"block_start":   m.Start.UTC().Format(time.RFC3339Nano),
+		"block_end":     m.End.UTC().Format(time.RFC3339Nano),
+		"message_count": strconv.Itoa(m.MessageCount),
+		"row_count":     strconv.Itoa(m.RowCount),
+		"first_seq":     strconv.FormatUint(m.FirstSeq, 10),
+		"last_seq":      strconv.FormatUint(m.LastSeq, 10),
+	}
+
+	if m.MessageCount > 0 {
+		md["oldest_message"] = m.OldestMessage.UTC().Format(time.RFC3339Nano)
+		md["newest_message"] = m.NewestMessage.UTC().Format(time.RFC3339Nano)
+	}
+
+	if ce := m.ContentEncoding(); ce != _EMPTY_ {
+		md["content_encoding"] = ce
+	}
+
+	if m.Encrypted {
+		md["encrypted"] = "true"
+	}
+
+	if m.Late {
+		md["late"] = "true"
+	}
+
+	return md
+}
+
+// merge returns the manifest of a block that had the contents of other merged into it
+func (m BlockManifest) merge(other BlockManifest) BlockManifest {
+	if !other.OldestMessage.IsZero() && (m.OldestMessage.IsZero() || other.OldestMessage.Before(m.OldestMessage)) {
+		m.OldestMessage = other.OldestMessage
+	}
+	if other.NewestMessage.After(m.NewestMessage) {
+		m.NewestMessage = other.NewestMessage
+	}
+
+	if other.MessageCount > 0 {
+		if m.MessageCount == 0 || other.FirstSeq < m.FirstSeq {
+			m.FirstSeq = other.FirstSeq
+		}
+		if other.LastSeq > m.LastSeq {
+			m.LastSeq = other.LastSeq
+		}
+	}
+
+	m.MessageCount += other.MessageCount
+	m.RowCount += other.RowCount
+	m.Late = m.Late || other.Late
+
+	return m
+}
+
+// manifestReader keeps the manifest of a block that had to be copied (see rewindable)
+type manifestReader struct {
+	io.ReadSeeker
+	manifest BlockManifest
+}
+
+func (m *manifestReader) Manifest() BlockManifest {
+	return m.manifest
+}
@@ -0,0 +1,178 @@
+package jetcapture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// MultiStoreMode decides when a MultiStore write is considered successful (and the block's messages get acked)
+type MultiStoreMode int
+
+const (
+	MultiStoreAll     MultiStoreMode = iota // every store must succeed
+	MultiStoreQuorum                        // at least `Quorum` stores must succeed
+	MultiStorePrimary                       // the first store must succeed, the others are best effort
+)
+
+var (
+	_ BlockStore[string] = &MultiStore[string]{}
+)
+
+// MultiStore writes each block to several stores (e.g. local NFS and Azure for disaster recovery). The stores are
+// written one after the other, re-reading the block from the start for each. `Options.OnStoreComplete` is called with
+// the result of each store, rather than once for the whole block. A store failing with `os.ErrExist` already has the
+// block, which counts as success. Write only fails with `os.ErrExist` itself if every store already had the block.
+type MultiStore[K DestKey] struct {
+	Stores []BlockStore[K]
+	Mode   MultiStoreMode
+	Quorum int // minimum number of successful stores for MultiStoreQuorum. defaults to a majority
+}
+
+func NewMultiStore[K DestKey](mode MultiStoreMode, stores ...BlockStore[K]) *MultiStore[K] {
+	return &MultiStore[K]{
+		Stores: stores,
+		Mode:   mode,
+	}
+}
+
+// Write returns the destination and byte count of the first successful store
+func (m *MultiStore[K]) Write(ctx context.Context, block io.Reader, destKey K, dir, fileName string) (string, int64, time.Duration, error) {
+	start := time.Now()
+
+	if len(m.Stores) == 0 {
+		return _EMPTY_, 0, 0, errors.New("MultiStore has no stores")
+	}
+
+	rs, err := rewindable(block)
+	if err != nil {
+		return _EMPTY_, 0, 0, err
+	}
+
+	var (
+		dest      string
+		written   int64
+		succeeded int
+		existed   int
+		primaryOK bool
+		errs      []error
+	)
+
+	reporter := takeStoreReporter[K](ctx)
+	if reporter != nil {
+		// nested stores report to this one rather than to the capture
+		ctx = withStoreReporter[K](ctx, nil)
+	}
+
+	for i, s := range m.Stores {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+
+		if i > 0 {
+			if _, err := rs.Seek(0, io.SeekStart); err != nil {
+				return dest, written, time.Since(start), err
+			}
+		}
+
+		p, n, dur, err := s.Write(ctx, rs, destKey, dir, fileName)
+
+		reporter.report(destKey, p, n, dur, err)
+
+		if errors.Is(err, os.ErrExist) {
+			// e.g. a `LocalFSStore` with NoClobber that stored the block in a previous run
+			log.Infof("store %d already has %s", i, fileName)
+			existed++
+			err = nil
+		}
+
+		if err != nil {
+			log.Errorf("store %d failed writing %s: %v", i, fileName, err)
+
+			errs = append(errs, fmt.Errorf("store %d: %w", i, err))
+
+			// no point in writing the remaining stores if the result is already a failure
+			if m.Mode == MultiStoreAll || (m.Mode == MultiStorePrimary && i == 0) {
+				break
+			}
+
+			continue
+		}
+
+		if succeeded == 0 {
+			dest, written = p, n
+		}
+
+		if i == 0 {
+			primaryOK = true
+		}
+
+		succeeded++
+	}
+
+	if err := m.result(succeeded, primaryOK, errs); err != nil {
+		return dest, written, time.Since(start), err
+	}
+
+	if existed == len(m.Stores) {
+		return dest, written, time.Since(start), Permanent(os.ErrExist)
+	}
+
+	return dest, written, time.Since(start), nil
+}
+
+func (m *MultiStore[K]) result(succeeded int, primaryOK bool, errs []error) error {
+	switch m.Mode {
+	case MultiStoreAll:
+		if succeeded == len(m.Stores) {
+			return nil
+		}
+	case MultiStoreQuorum:
+		quorum := m.Quorum
+		if quorum <= 0 {
+			quorum = len(m.Stores)/2 + 1
+		}
+		if succeeded >= quorum {
+			return nil
+		}
+	case MultiStorePrimary:
+		if primaryOK {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unknown MultiStoreMode %d", m.Mode)
+	}
+
+	return fmt.Errorf("%d of %d stores succeeded: %w", succeeded, len(m.Stores), errors.Join(errs...))
+}
+
+type storeReporterKey struct{}
+
+// storeReporter passes `Options.OnStoreComplete` to stores that report the result of each of their writes
+type storeReporter[K DestKey] struct {
+	onStoreComplete func(K, string, int64, time.Duration, error)
+	taken           bool // a store reported its writes, so the capture doesn't report the block again
+}
+
+func withStoreReporter[K DestKey](ctx context.Context, r *storeReporter[K]) context.Context {
+	return context.WithValue(ctx, storeReporterKey{}, r)
+}
+
+// takeStoreReporter returns the reporter of the capture, if any, and marks it as used
+func takeStoreReporter[K DestKey](ctx context.Context) *storeReporter[K] {
+	r, _ := ctx.Value(storeReporterKey{}).(*storeReporter[K])
+	if r != nil {
+		r.taken = true
+	}
+	return r
+}
+
+func (r *storeReporter[K]) report(dk K, p string, n int64, dur time.Duration, err error) {
+	if r != nil {
+		r.onStoreComplete(dk, p, n, dur, err)
+	}
+}
@@ -0,0 +1,134 @@
+package jetcapture
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testStore is a BlockStore backed by a function
+type testStore[K DestKey] func(ctx context.Context, block io.Reader, destKey K, dir, fileName string) (string, int64, time.Duration, error)
+
+func (s testStore[K]) Write(ctx context.Context, block io.Reader, destKey K, dir, fileName string) (string, int64, time.Duration, error) {
+	return s(ctx, block, destKey, dir, fileName)
+}
+
+func failingStore[K DestKey](err error) testStore[K] {
+	return func(_ context.Context, block io.Reader, _ K, _, _ string) (string, int64, time.Duration, error) {
+		// consume part of the block to make sure the next store starts from the beginning
+		_, _ = block.Read(make([]byte, 3))
+		return _EMPTY_, 0, 0, err
+	}
+}
+
+func TestMultiStore(t *testing.T) {
+	assert := require.New(t)
+
+	ctx := context.Background()
+	content := []byte("hello multi store")
+	failure := errors.New("nope")
+
+	dir1, dir2 := t.TempDir(), t.TempDir()
+
+	primary := SingleDirStore[string](dir1)
+	secondary := SingleDirStore[string](dir2)
+
+	var results []error
+
+	write := func(m *MultiStore[string], block io.Reader) (string, error) {
+		results = nil
+		reporter := &storeReporter[string]{onStoreComplete: func(_ string, _ string, _ int64, _ time.Duration, err error) {
+			results = append(results, err)
+		}}
+		p, _, _, err := m.Write(withStoreReporter(ctx, reporter), block, "dk", "dir", "file")
+		assert.True(reporter.taken)
+		return p, err
+	}
+
+	// seekable and non-seekable blocks
+	for _, block := range []io.Reader{bytes.NewReader(content), io.MultiReader(bytes.NewReader(content))} {
+		p, err := write(NewMultiStore(MultiStoreAll, primary, secondary), block)
+		assert.Nil(err)
+		assert.Equal(filepath.Join(dir1, "dir", "file"), p)
+		assert.Equal([]error{nil, nil}, results)
+
+		for _, d := range []string{dir1, dir2} {
+			b, err := os.ReadFile(filepath.Join(d, "dir", "file"))
+			assert.Nil(err)
+			assert.Equal(content, b)
+		}
+	}
+
+	_, err := write(NewMultiStore[string](MultiStoreAll, failingStore[string](failure), primary), bytes.NewReader(content))
+	assert.ErrorIs(err, failure)
+	assert.Len(results, 1)
+
+	p, err := write(NewMultiStore[string](MultiStorePrimary, primary, failingStore[string](failure)), bytes.NewReader(content))
+	assert.Nil(err)
+	assert.Equal(filepath.Join(dir1, "dir", "file"), p)
+	assert.Equal([]error{nil, failure}, results)
+
+	_, err = write(NewMultiStore[string](MultiStorePrimary, failingStore[string](failure), primary), bytes.NewReader(content))
+	assert.ErrorIs(err, failure)
+	assert.Len(results, 1)
+
+	p, err = write(NewMultiStore[string](MultiStoreQuorum, failingStore[string](failure), primary, secondary), bytes.NewReader(content))
+	assert.Nil(err)
+	assert.Equal(filepath.Join(dir1, "dir", "file"), p)
+	assert.Equal([]error{failure, nil, nil}, results)
+
+	_, err = write(NewMultiStore[string](MultiStoreQuorum, failingStore[string](failure), failingStore[string](failure), primary), bytes.NewReader(content))
+	assert.ErrorIs(err, failure)
+	assert.Len(results, 3)
+
+	// a store that already has the block counts as stored, and the others are still written
+	noClobber := &LocalFSStore[string]{Resolver: func(string) (string, error) { return dir1, nil }, NoClobber: true}
+	dir3 := t.TempDir()
+
+	p, err = write(NewMultiStore[string](MultiStoreAll, noClobber, SingleDirStore[string](dir3)), bytes.NewReader(content))
+	assert.Nil(err)
+	assert.Equal(filepath.Join(dir1, "dir", "file"), p)
+	assert.Len(results, 2)
+	assert.ErrorIs(results[0], os.ErrExist)
+	assert.Nil(results[1])
+
+	b, err := os.ReadFile(filepath.Join(dir3, "dir", "file"))
+	assert.Nil(err)
+	assert.Equal(content, b)
+
+	// only if every store has it, the write fails with os.ErrExist
+	_, err = write(NewMultiStore[string](MultiStoreAll, noClobber, noClobber), bytes.NewReader(content))
+	assert.ErrorIs(err, os.ErrExist)
+	assert.True(onlyErrExist(err))
+
+	// nested stores are reported as one
+	_, err = write(NewMultiStore[string](MultiStoreAll, NewMultiStore(MultiStoreAll, primary, secondary), primary), bytes.NewReader(content))
+	assert.Nil(err)
+	assert.Equal([]error{nil, nil}, results)
+}
+
+func TestMemoryBufferRewind(t *testing.T) {
+	assert := require.New(t)
+
+	buf := newMemoryBuffer()
+
+	_, err := buf.Write([]byte("hello"))
+	assert.Nil(err)
+	assert.Nil(buf.DoneWriting())
+
+	for i := 0; i < 2; i++ {
+		_, err := buf.Seek(0, io.SeekStart)
+		assert.Nil(err)
+
+		b, err := io.ReadAll(buf)
+		assert.Nil(err)
+		assert.Equal("hello", string(b))
+	}
+}
@@ -0,0 +1,121 @@
+package jetcapture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+var (
+	_ BlockStore[string] = &ObjectStoreBlockStore[string]{}
+)
+
+// ObjectStoreBlockStore archives blocks into JetStream Object Store buckets, e.g. on another NATS cluster for edge
+// sites without cloud storage. Objects are named `dir/fileName` and carry the block manifest as metadata.
+type ObjectStoreBlockStore[K DestKey] struct {
+	js       nats.JetStreamContext
+	bucketFn func(destKey K) (string, error)
+
+	// CreateBucket is optional. If set, missing buckets are created using the returned config (the bucket name is
+	// filled in). Otherwise writing to a missing bucket fails.
+	CreateBucket func(bucket string) *nats.ObjectStoreConfig
+
+	buckets   map[string]nats.ObjectStore
+	bucketsMu sync.Mutex
+}
+
+// NewObjectStoreBlockStore uses the JetStream context (which may be bound to a different connection than the capture)
+// and maps each DestKey to a bucket name
+func NewObjectStoreBlockStore[K DestKey](js nats.JetStreamContext, bucketFn func(destKey K) (string, error)) (*ObjectStoreBlockStore[K], error) {
+	if js == nil {
+		return nil, errors.New("JetStream context not set")
+	}
+
+	if bucketFn == nil {
+		return nil, errors.New("bucket function not set")
+	}
+
+	return &ObjectStoreBlockStore[K]{
+		js:       js,
+		bucketFn: bucketFn,
+		buckets:  map[string]nats.ObjectStore{},
+	}, nil
+}
+
+func (o *ObjectStoreBlockStore[K]) bucket(name string) (nats.ObjectStore, error) {
+	o.bucketsMu.Lock()
+	defer o.bucketsMu.Unlock()
+
+	if obs, ok := o.buckets[name]; ok {
+		return obs, nil
+	}
+
+	obs, err := o.js.ObjectStore(name)
+	if errors.Is(err, nats.ErrStreamNotFound) && o.CreateBucket != nil {
+		cfg := o.CreateBucket(name)
+		if cfg == nil {
+			cfg = &nats.ObjectStoreConfig{}
+		}
+		cfg.Bucket = name
+
+		log.Infof("creating object store bucket %s", name)
+
+		obs, err = o.js.CreateObjectStore(cfg)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("object store bucket %s: %w", name, err)
+	}
+
+	o.buckets[name] = obs
+
+	return obs, nil
+}
+
+func (o *ObjectStoreBlockStore[K]) Write(ctx context.Context, block io.Reader, destKey K, dir, fileName string) (string, int64, time.Duration, error) {
+	start := time.Now()
+
+	bucket, err := o.bucketFn(destKey)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	obs, err := o.bucket(bucket)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	name := path.Join(dir, fileName)
+
+	log.Infof("writing block to object store %s/%s", bucket, name)
+
+	meta := &nats.ObjectMeta{
+		Name:    name,
+		Headers: nats.Header{},
+	}
+
+	contentType, contentEncoding := blockContentHeaders(block, fileName)
+
+	meta.Headers.Set("Content-Type", contentType)
+	if contentEncoding != _EMPTY_ {
+		meta.Headers.Set("Content-Encoding", contentEncoding)
+	}
+
+	if m, ok := ManifestOf(block); ok {
+		meta.Description = "jetcapture block " + m.ID
+		meta.Metadata = m.Metadata()
+	}
+
+	info, err := obs.Put(meta, block, nats.Context(ctx))
+	if err != nil {
+		return name, 0, time.Since(start), err
+	}
+
+	return name, int64(info.Size), time.Since(start), nil
+}
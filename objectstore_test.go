@@ -0,0 +1,70 @@
+package jetcapture
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObjectStoreBlockStore(t *testing.T) {
+	assert := require.New(t)
+
+	s := runBasicJetStreamServer(t)
+	t.Cleanup(s.Shutdown)
+
+	nc := clientConnectToServer(t, s)
+	t.Cleanup(nc.Close)
+
+	js, err := nc.JetStream()
+	assert.Nil(err)
+
+	store, err := NewObjectStoreBlockStore[string](js, func(dk string) (string, error) {
+		return "backup-" + dk, nil
+	})
+	assert.Nil(err)
+
+	ctx := context.Background()
+
+	block := &manifestReader{
+		ReadSeeker: bytes.NewReader([]byte("hello object store")),
+		manifest: BlockManifest{
+			ID:          "01ARZ3NDEKTSV4RRFFQ69G5FAV",
+			RowCount:    3,
+			FirstSeq:    10,
+			LastSeq:     11,
+			Compression: GZip,
+		},
+	}
+
+	// missing bucket
+	_, _, _, err = store.Write(ctx, block, "k1", "2024/01/02/03/00", "backup-1.csv.gz")
+	assert.True(errors.Is(err, nats.ErrStreamNotFound))
+
+	store.CreateBucket = func(bucket string) *nats.ObjectStoreConfig {
+		return &nats.ObjectStoreConfig{Storage: nats.MemoryStorage}
+	}
+
+	p, n, _, err := store.Write(ctx, block, "k1", "2024/01/02/03/00", "backup-1.csv.gz")
+	assert.Nil(err)
+	assert.Equal("2024/01/02/03/00/backup-1.csv.gz", p)
+	assert.EqualValues(18, n)
+
+	obs, err := js.ObjectStore("backup-k1")
+	assert.Nil(err)
+
+	b, err := obs.GetBytes(p)
+	assert.Nil(err)
+	assert.Equal("hello object store", string(b))
+
+	info, err := obs.GetInfo(p)
+	assert.Nil(err)
+	assert.Equal("text/csv", info.Headers.Get("Content-Type"))
+	assert.Equal("gzip", info.Headers.Get("Content-Encoding"))
+	assert.Equal("3", info.Metadata["row_count"])
+	assert.Equal("10", info.Metadata["first_seq"])
+	assert.Equal("01ARZ3NDEKTSV4RRFFQ69G5FAV", info.Metadata["block_id"])
+}
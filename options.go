@@ -25,18 +25,58 @@ const (
 )
 
 const (
-	DefaultMaxAge = time.Minute * 15
+	DefaultMaxAge          = time.Minute * 15
+	DefaultFetchBatch      = 100 // used if neither `Options.FetchBatch` nor the consumer's MaxRequestBatch are set
+	DefaultFetchTimeout    = time.Second
+	DefaultConsumerRefresh = time.Minute
+)
+
+// LatePolicy decides where messages go that belong to an interval which already expired (i.e. its blocks were stored)
+type LatePolicy string
+
+const (
+	LateNewBlock LatePolicy = "new-block" // write a new block for the old interval (the default)
+	LateSeparate LatePolicy = "separate"  // write into a dedicated `late-` block per DestKey stored with the current interval
+	LateAppend   LatePolicy = "append"    // write into the current interval's block
+	LateRewrite  LatePolicy = "rewrite"   // merge into the stored block of the old interval. requires a BlockRewriter store
+)
+
+// MemoryPolicy decides how jetcapture gets back under `Options.MaxMemoryBytes`. Either way, the fetch batch is shrunk
+// as the memory usage approaches the limit.
+type MemoryPolicy string
+
+const (
+	MemorySpill    MemoryPolicy = "spill"    // move the largest blocks to disk buffers in TempDir (the default)
+	MemoryFinalize MemoryPolicy = "finalize" // store the oldest blocks early
 )
 
 type Options[P Payload, K DestKey] struct {
-	NATSStreamName   string        // which stream should jetcapture bind to
-	NATSConsumerName string        // which consumer should jetcapture bind to
-	Compression      Compression   // apply compression to the resulting files
-	Suffix           string        // add a suffix
-	BufferToDisk     bool          // should jetcapture buffer to disk using temp files, or keep blocks in memory
-	MaxAge           time.Duration // what is the max duration for a single block
-	MaxMessages      int           // rough limit to the number of messages in a block before a new one is created
-	TempDir          string        // override the default OS temp dir
+	NATSStreamName    string        // which stream should jetcapture bind to
+	NATSConsumerName  string        // which consumer should jetcapture bind to
+	Compression       Compression   // apply compression to the resulting files
+	Suffix            string        // add a suffix
+	BufferToDisk      bool          // should jetcapture buffer to disk using temp files, or keep blocks in memory
+	MaxAge            time.Duration // what is the max duration for a single block
+	Window            Window        // optional calendar or timezone aligned block intervals. defaults to EpochWindow(MaxAge)
+	FlushGrace        time.Duration // keep blocks open this long after their interval ends for out-of-order messages
+	IdleFlush         bool          // also finalize blocks based on wall-clock time, so quiet streams still produce blocks
+	LatePolicy        LatePolicy    // what to do with messages for intervals that already expired. defaults to LateNewBlock
+	TimeFallback      TimeFallback  // what to do with messages without a usable time. defaults to TimeFallbackStream
+	MaxPastTime       time.Duration // optional limit on how far an extracted time may be before the stream timestamp
+	MaxFutureTime     time.Duration // optional limit on how far an extracted time may be after the stream timestamp
+	MaxMessages       int           // rough limit to the number of messages in a block before a new one is created
+	MaxMemoryBytes    int64         // optional limit for the in-memory buffers of all blocks (i.e. without BufferToDisk)
+	MemoryPolicy      MemoryPolicy  // what to do once MaxMemoryBytes is exceeded. defaults to MemorySpill
+	MaxSpoolBytes     int64         // optional limit for the temp files of all blocks (i.e. BufferToDisk or spilled)
+	MinFreeSpoolBytes uint64        // optional free space to keep on the file system of TempDir while temp files are in use
+	ExactlyOnce       bool          // name blocks after their DestKey, window and first stream sequence, and drop redelivered messages
+	TempDir           string        // override the default OS temp dir
+	FetchBatch        int           // messages per fetch. defaults to the consumer's MaxRequestBatch, or DefaultFetchBatch
+	FetchMaxBytes     int           // optional limit of the bytes per fetch (see `nats.PullMaxBytes`)
+	FetchTimeout      time.Duration // how long a fetch waits for messages. defaults to DefaultFetchTimeout
+	AdaptiveFetch     bool          // grow or shrink the fetch batch (up to FetchBatch) based on fetch latency and ack room
+	ConsumerRefresh   time.Duration // how often the consumer info is read to pick up changes. defaults to DefaultConsumerRefresh
+	Encryption        KeyWrapper    // optionally encrypt each block with a fresh AES-256-GCM data key wrapped by this KeyWrapper
 
 	// TODO
 	// MaxSize        int
@@ -45,9 +85,14 @@ type Options[P Payload, K DestKey] struct {
 	// WriteEmptyFile bool
 
 	MessageDecoder  func(*nats.Msg) (P, K, error)
+	Filter          Filter[P, K]     // optional filter to drop or re-route decoded messages before they are written
+	Transforms      []Transform[P]   // optional transforms (e.g. redaction) applied in order before a payload is written
+	TimeExtractor   TimeExtractor[P] // optional message time (e.g. event time) deciding its block instead of the stream timestamp
 	WriterFactory   func() FormattedDataWriter[P]
 	Store           BlockStore[K]
+	Catalog         Catalog                                      // optionally record each stored block (e.g. BoltCatalog or KVCatalog)
 	OnStoreComplete func(K, string, int64, time.Duration, error) // optional callback for metrics capture
+	OnMessageError  func(*nats.Msg, error)                       // optional callback for messages that failed decoding or transforming
 }
 
 func (o *Options[P, K]) Build() *Capture[P, K] {
@@ -89,6 +134,71 @@ func (o *Options[P, K]) Validate() error {
 		o.MaxAge = DefaultMaxAge
 	}
 
+	if o.Window == nil {
+		o.Window = EpochWindow(o.MaxAge)
+	}
+
+	if o.LatePolicy == _EMPTY_ {
+		o.LatePolicy = LateNewBlock
+	}
+
+	switch o.LatePolicy {
+	case LateNewBlock, LateSeparate, LateAppend:
+	case LateRewrite:
+		if _, ok := o.Store.(BlockRewriter[K]); !ok {
+			return errors.New("LateRewrite requires a Store that implements BlockRewriter")
+		}
+		if o.Encryption != nil {
+			return errors.New("LateRewrite can't be used with Encryption")
+		}
+	default:
+		return errors.New("unknown late policy")
+	}
+
+	if o.TimeFallback == _EMPTY_ {
+		o.TimeFallback = TimeFallbackStream
+	}
+
+	switch o.TimeFallback {
+	case TimeFallbackStream, TimeFallbackDrop, TimeFallbackFail:
+	default:
+		return errors.New("unknown time fallback")
+	}
+
+	if o.MaxMemoryBytes < 0 {
+		return errors.New("MaxMemoryBytes can't be negative")
+	}
+
+	if o.FetchBatch < 0 || o.FetchMaxBytes < 0 || o.FetchTimeout < 0 || o.ConsumerRefresh < 0 {
+		return errors.New("FetchBatch, FetchMaxBytes, FetchTimeout and ConsumerRefresh can't be negative")
+	}
+
+	if o.FetchTimeout == 0 {
+		o.FetchTimeout = DefaultFetchTimeout
+	}
+
+	if o.ConsumerRefresh == 0 {
+		o.ConsumerRefresh = DefaultConsumerRefresh
+	}
+
+	if o.MaxSpoolBytes < 0 {
+		return errors.New("MaxSpoolBytes can't be negative")
+	}
+
+	if o.MemoryPolicy == _EMPTY_ {
+		o.MemoryPolicy = MemorySpill
+	}
+
+	switch o.MemoryPolicy {
+	case MemorySpill, MemoryFinalize:
+	default:
+		return errors.New("unknown memory policy")
+	}
+
+	if o.MaxPastTime < 0 || o.MaxFutureTime < 0 {
+		return errors.New("MaxPastTime and MaxFutureTime can't be negative")
+	}
+
 	return nil
 }
 
@@ -0,0 +1,183 @@
+package jetcapture
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// PruneOptions configures Prune. Blocks are removed when they are older than their retention, or (oldest first,
+// across all destinations) while the free space is below MinFreeBytes. The newest KeepLast blocks of each destination
+// are never removed.
+type PruneOptions struct {
+	Retention    time.Duration            // remove blocks with a partition older than this. 0 disables
+	Retentions   map[string]time.Duration // per destination directory (relative to the root) overrides of Retention
+	KeepLast     int                      // always keep the newest N blocks per destination directory
+	MinFreeBytes uint64                   // remove the oldest blocks until at least this many bytes are free. 0 disables
+	DryRun       bool                     // only report what would be removed
+	Catalog      Catalog                  // optionally remove the entries of removed blocks from this catalog
+
+	Location *time.Location   // zone the partition directories were rendered in. defaults to `time.Local`
+	Now      func() time.Time // defaults to `time.Now`
+}
+
+// PruneResult lists what Prune removed (or would remove with DryRun)
+type PruneResult struct {
+	Removed     []StoredBlock
+	RemovedDirs []string
+	Bytes       int64
+}
+
+// Prune applies retention to a tree written by LocalFSStore and then removes any partition directories left empty
+func Prune(root string, opts PruneOptions) (PruneResult, error) {
+	var result PruneResult
+
+	now := time.Now()
+	if opts.Now != nil {
+		now = opts.Now()
+	}
+
+	blocks, err := ListBlocks(root, opts.Location)
+	if err != nil {
+		return result, err
+	}
+
+	// blocks are sorted by destination and partition, so the newest KeepLast blocks are at the end of each run
+	var candidates []StoredBlock
+
+	for i := 0; i < len(blocks); {
+		j := i
+		for j < len(blocks) && blocks[j].DestDir == blocks[i].DestDir {
+			j++
+		}
+
+		if n := j - i - opts.KeepLast; n > 0 {
+			candidates = append(candidates, blocks[i:i+n]...)
+		}
+
+		i = j
+	}
+
+	remove := func(b StoredBlock) error {
+		log.Debugw("pruning block", "path", b.Path, "partition", b.Partition, "size", b.Size, "dry_run", opts.DryRun)
+
+		if !opts.DryRun {
+			for _, p := range append(b.Sidecars, b.Path) {
+				if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+			}
+
+			if opts.Catalog != nil {
+				id := BlockIDOf(filepath.Base(b.Path))
+				if err := opts.Catalog.Remove(context.Background(), id); err != nil {
+					log.Errorw("unable to remove block from catalog", "path", b.Path, "id", id, "error", err)
+				}
+			}
+		}
+
+		result.Removed = append(result.Removed, b)
+		result.Bytes += b.Size
+
+		return nil
+	}
+
+	var remaining []StoredBlock
+
+	for _, b := range candidates {
+		retention := opts.Retention
+		if r, ok := opts.Retentions[b.DestDir]; ok {
+			retention = r
+		}
+
+		if retention > 0 && b.Partition.Before(now.Add(-retention)) {
+			if err := remove(b); err != nil {
+				return result, err
+			}
+		} else {
+			remaining = append(remaining, b)
+		}
+	}
+
+	if opts.MinFreeBytes > 0 {
+		free, err := diskFree(root)
+		if err != nil {
+			return result, err
+		}
+
+		// count removed bytes as freed instead of checking again, which works for dry runs and for file systems
+		// (e.g. NFS) that are slow to report space as free
+		free += uint64(result.Bytes)
+
+		sort.SliceStable(remaining, func(i, j int) bool {
+			return remaining[i].Partition.Before(remaining[j].Partition)
+		})
+
+		for _, b := range remaining {
+			if free >= opts.MinFreeBytes {
+				break
+			}
+
+			if err := remove(b); err != nil {
+				return result, err
+			}
+
+			free += uint64(b.Size)
+		}
+	}
+
+	if opts.DryRun {
+		return result, nil
+	}
+
+	dirs, err := removeEmptyPartitions(result.Removed)
+	result.RemovedDirs = dirs
+
+	return result, err
+}
+
+// removeEmptyPartitions removes the partition directories of the removed blocks, and their parents up to the
+// destination directory, if they're empty
+func removeEmptyPartitions(removed []StoredBlock) ([]string, error) {
+	var (
+		removedDirs []string
+		seen        = map[string]bool{}
+	)
+
+	for _, b := range removed {
+		dir := filepath.Dir(b.Path)
+		if seen[dir] {
+			continue
+		}
+
+		seen[dir] = true
+
+		// YYYY/MM/DD/HH/mm
+		for level := 0; level < 5; level++ {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					break
+				}
+				return removedDirs, err
+			}
+
+			if len(entries) > 0 {
+				break
+			}
+
+			if err := os.Remove(dir); err != nil {
+				return removedDirs, err
+			}
+
+			removedDirs = append(removedDirs, dir)
+
+			dir = filepath.Dir(dir)
+		}
+	}
+
+	return removedDirs, nil
+}
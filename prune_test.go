@@ -0,0 +1,191 @@
+package jetcapture
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writePruneTree(t *testing.T, files ...string) string {
+	root := t.TempDir()
+
+	for _, f := range files {
+		p := filepath.Join(root, filepath.FromSlash(f))
+		require.Nil(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.Nil(t, os.WriteFile(p, []byte("0123456789"), 0644))
+	}
+
+	return root
+}
+
+func TestListBlocks(t *testing.T) {
+	assert := require.New(t)
+
+	root := writePruneTree(t,
+		"b/2024/01/02/03/15/backup-2.json",
+		"a/x/2024/01/02/03/00/backup-1.json",
+		"a/x/2024/01/02/03/00/backup-1.json.sum",
+		"a/x/2024/01/02/03/00/.backup-3.json.tmp-abc",
+		"a/x/2024/13/02/03/00/backup-bad.json",
+		"a/x/notes.txt",
+		"2023/12/31/23/45/backup-0.json",
+	)
+
+	blocks, err := ListBlocks(root, time.UTC)
+	assert.Nil(err)
+	assert.Len(blocks, 3)
+
+	assert.Equal(".", blocks[0].DestDir)
+	assert.Equal(time.Date(2023, 12, 31, 23, 45, 0, 0, time.UTC), blocks[0].Partition)
+
+	assert.Equal(filepath.Join("a", "x"), blocks[1].DestDir)
+	assert.EqualValues(10, blocks[1].Size)
+	assert.Equal([]string{blocks[1].Path + ".sum"}, blocks[1].Sidecars)
+
+	assert.Equal("b", blocks[2].DestDir)
+	assert.Equal(time.Date(2024, 1, 2, 3, 15, 0, 0, time.UTC), blocks[2].Partition)
+}
+
+func TestPrune(t *testing.T) {
+	files := []string{
+		"a/2024/01/01/00/00/backup-1.json",
+		"a/2024/01/01/00/00/backup-1.json.sum",
+		"a/2024/01/02/00/00/backup-2.json",
+		"a/2024/01/03/00/00/backup-3.json",
+		"b/2024/01/01/00/00/backup-4.json",
+		"b/2024/01/03/00/00/backup-5.json",
+	}
+
+	opts := PruneOptions{
+		Retention:  36 * time.Hour,
+		Retentions: map[string]time.Duration{"b": 72 * time.Hour},
+		Location:   time.UTC,
+		Now:        func() time.Time { return time.Date(2024, 1, 3, 13, 0, 0, 0, time.UTC) },
+	}
+
+	names := func(blocks []StoredBlock) (n []string) {
+		for _, b := range blocks {
+			n = append(n, filepath.Base(b.Path))
+		}
+		return
+	}
+
+	t.Run("retention", func(t *testing.T) {
+		assert := require.New(t)
+
+		root := writePruneTree(t, files...)
+
+		dry := opts
+		dry.DryRun = true
+
+		result, err := Prune(root, dry)
+		assert.Nil(err)
+		assert.Equal([]string{"backup-1.json", "backup-2.json"}, names(result.Removed))
+		assert.EqualValues(20, result.Bytes)
+		assert.FileExists(filepath.Join(root, files[0]))
+
+		result, err = Prune(root, opts)
+		assert.Nil(err)
+		assert.Equal([]string{"backup-1.json", "backup-2.json"}, names(result.Removed))
+		assert.NoFileExists(filepath.Join(root, files[0]))
+		assert.NoFileExists(filepath.Join(root, files[1]))
+		assert.FileExists(filepath.Join(root, files[3]))
+
+		// the empty day directories are gone, but the month with remaining blocks isn't
+		assert.NoDirExists(filepath.Join(root, "a/2024/01/01"))
+		assert.NoDirExists(filepath.Join(root, "a/2024/01/02"))
+		assert.DirExists(filepath.Join(root, "a/2024/01/03/00/00"))
+		assert.Len(result.RemovedDirs, 6)
+	})
+
+	t.Run("keep last", func(t *testing.T) {
+		assert := require.New(t)
+
+		root := writePruneTree(t, files...)
+
+		keep := opts
+		keep.Retention = time.Hour
+		keep.KeepLast = 2
+
+		result, err := Prune(root, keep)
+		assert.Nil(err)
+		assert.Equal([]string{"backup-1.json"}, names(result.Removed))
+	})
+
+	t.Run("min free", func(t *testing.T) {
+		assert := require.New(t)
+
+		root := writePruneTree(t, files...)
+
+		free, err := diskFree(root)
+		assert.Nil(err)
+
+		minFree := opts
+		minFree.Retention = 0
+		minFree.Retentions = nil
+		minFree.KeepLast = 1
+		minFree.DryRun = true
+		minFree.MinFreeBytes = free + 15
+
+		// oldest first across destinations until enough would be freed
+		result, err := Prune(root, minFree)
+		assert.Nil(err)
+		assert.Equal([]string{"backup-1.json", "backup-4.json"}, names(result.Removed))
+	})
+
+	t.Run("catalog", func(t *testing.T) {
+		assert := require.New(t)
+
+		root := writePruneTree(t, files...)
+
+		catalog, err := OpenBoltCatalog(filepath.Join(t.TempDir(), "catalog.db"))
+		assert.Nil(err)
+		t.Cleanup(func() { _ = catalog.Close() })
+
+		ctx := context.Background()
+
+		blocks, err := ListBlocks(root, time.UTC)
+		assert.Nil(err)
+
+		for _, b := range blocks {
+			assert.Nil(catalog.Add(ctx, CatalogEntry{
+				DestKey: b.DestDir,
+				Path:    b.Path,
+				ID:      BlockIDOf(filepath.Base(b.Path)),
+				Start:   b.Partition,
+				End:     b.Partition.Add(time.Minute),
+			}))
+		}
+
+		withCatalog := opts
+		withCatalog.Catalog = catalog
+		withCatalog.DryRun = true
+
+		_, err = Prune(root, withCatalog)
+		assert.Nil(err)
+
+		entries, err := catalog.Query(ctx, CatalogQuery{})
+		assert.Nil(err)
+		assert.Len(entries, 5)
+
+		withCatalog.DryRun = false
+
+		_, err = Prune(root, withCatalog)
+		assert.Nil(err)
+
+		entries, err = catalog.Query(ctx, CatalogQuery{})
+		assert.Nil(err)
+
+		var ids []string
+		for _, e := range entries {
+			assert.FileExists(e.Path)
+			ids = append(ids, e.ID)
+		}
+
+		assert.Equal([]string{"3", "4", "5"}, ids)
+	})
+}
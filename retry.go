@@ -0,0 +1,152 @@
+package jetcapture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultRetryAttempts   = 5
+	DefaultRetryMinBackoff = time.Second
+	DefaultRetryMaxBackoff = time.Minute
+)
+
+var (
+	_ BlockStore[string] = &RetryingStore[string]{}
+)
+
+// Backoff returns how long to wait before the given retry (1 for the first retry)
+type Backoff func(retry int) time.Duration
+
+// ExponentialBackoff doubles the delay for each retry starting at min and capped at max. Up to 20% of jitter is
+// subtracted so that several captures don't retry in lockstep.
+func ExponentialBackoff(min, max time.Duration) Backoff {
+	return func(retry int) time.Duration {
+		d := max
+		if retry < 32 {
+			if exp := min << (retry - 1); exp > 0 && exp < max {
+				d = exp
+			}
+		}
+		return d - time.Duration(rand.Int64N(int64(d)/5+1))
+	}
+}
+
+type retryableError struct {
+	err       error
+	retryable bool
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// Retryable marks an error as transient (e.g. a 503 response) so a RetryingStore will retry it
+func Retryable(err error) error {
+	return &retryableError{err: err, retryable: true}
+}
+
+// Permanent marks an error as permanent (e.g. a 403 response) so a RetryingStore won't retry it
+func Permanent(err error) error {
+	return &retryableError{err: err, retryable: false}
+}
+
+// IsRetryable is the default retry classifier. Errors marked with Retryable or Permanent are classified accordingly,
+// context errors are never retried, and network timeouts and dropped or refused connections are assumed to be
+// transient. Everything else (e.g. resolver, path or permission errors) is not retried, as retries block the capture
+// and a permanent error would only hold up its messages until they're redelivered.
+func IsRetryable(err error) bool {
+	var re *retryableError
+	if errors.As(err, &re) {
+		return re.retryable
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+
+	for _, transient := range []error{syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.ECONNABORTED, syscall.EPIPE} {
+		if errors.Is(err, transient) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RetryingStore wraps a store and retries failed writes, re-reading the block from the start for each attempt. This
+// avoids having all the block's messages redelivered (and the whole window re-written) due to a transient error.
+type RetryingStore[K DestKey] struct {
+	Store       BlockStore[K]
+	MaxAttempts int              // total number of attempts, including the first. defaults to DefaultRetryAttempts
+	Backoff     Backoff          // defaults to an ExponentialBackoff using DefaultRetryMinBackoff and DefaultRetryMaxBackoff
+	Retryable   func(error) bool // defaults to IsRetryable
+}
+
+func NewRetryingStore[K DestKey](store BlockStore[K]) *RetryingStore[K] {
+	return &RetryingStore[K]{
+		Store: store,
+	}
+}
+
+func (r *RetryingStore[K]) Write(ctx context.Context, block io.Reader, destKey K, dir, fileName string) (string, int64, time.Duration, error) {
+	start := time.Now()
+
+	var (
+		maxAttempts = r.MaxAttempts
+		backoff     = r.Backoff
+		retryable   = r.Retryable
+	)
+
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultRetryAttempts
+	}
+
+	if backoff == nil {
+		backoff = ExponentialBackoff(DefaultRetryMinBackoff, DefaultRetryMaxBackoff)
+	}
+
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	rs, err := rewindable(block)
+	if err != nil {
+		return _EMPTY_, 0, 0, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		p, n, _, err := r.Store.Write(ctx, rs, destKey, dir, fileName)
+		if err == nil {
+			return p, n, time.Since(start), nil
+		}
+
+		if attempt >= maxAttempts || !retryable(err) {
+			return p, n, time.Since(start), fmt.Errorf("giving up after %d attempt(s): %w", attempt, err)
+		}
+
+		delay := backoff(attempt)
+
+		log.Warnf("writing %s failed (attempt %d of %d), retrying in %s: %v", fileName, attempt, maxAttempts, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return p, n, time.Since(start), fmt.Errorf("%w (last error: %v)", ctx.Err(), err)
+		case <-time.After(delay):
+		}
+
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			return p, n, time.Since(start), err
+		}
+	}
+}
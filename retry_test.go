@@ -0,0 +1,111 @@
+package jetcapture
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryingStore(t *testing.T) {
+	assert := require.New(t)
+
+	ctx := context.Background()
+	failure := Retryable(errors.New("transient"))
+
+	var (
+		attempts int
+		stored   []byte
+	)
+
+	flaky := func(failures int, err error) BlockStore[string] {
+		attempts = 0
+		return testStore[string](func(_ context.Context, block io.Reader, _ string, _, fileName string) (string, int64, time.Duration, error) {
+			attempts++
+			b, rerr := io.ReadAll(block)
+			assert.Nil(rerr)
+			if attempts <= failures {
+				return _EMPTY_, 0, 0, err
+			}
+			stored = b
+			return fileName, int64(len(b)), 0, nil
+		})
+	}
+
+	newStore := func(store BlockStore[string]) *RetryingStore[string] {
+		r := NewRetryingStore[string](store)
+		r.Backoff = func(int) time.Duration { return time.Millisecond }
+		return r
+	}
+
+	p, n, _, err := newStore(flaky(2, failure)).Write(ctx, bytes.NewReader([]byte("block")), "dk", "dir", "file")
+	assert.Nil(err)
+	assert.Equal("file", p)
+	assert.EqualValues(5, n)
+	assert.Equal(3, attempts)
+	assert.Equal("block", string(stored))
+
+	_, _, _, err = newStore(flaky(10, failure)).Write(ctx, bytes.NewReader([]byte("block")), "dk", "dir", "file")
+	assert.ErrorIs(err, failure)
+	assert.Equal(DefaultRetryAttempts, attempts)
+
+	_, _, _, err = newStore(flaky(10, Permanent(failure))).Write(ctx, bytes.NewReader([]byte("block")), "dk", "dir", "file")
+	assert.ErrorIs(err, failure)
+	assert.Equal(1, attempts)
+
+	// e.g. a failing Resolver, which retrying won't fix
+	unclassified := errors.New("no destination for key")
+	_, _, _, err = newStore(flaky(10, unclassified)).Write(ctx, bytes.NewReader([]byte("block")), "dk", "dir", "file")
+	assert.ErrorIs(err, unclassified)
+	assert.Equal(1, attempts)
+
+	r := newStore(flaky(10, failure))
+	r.Retryable = func(error) bool { return false }
+	_, _, _, err = r.Write(ctx, bytes.NewReader([]byte("block")), "dk", "dir", "file")
+	assert.ErrorIs(err, failure)
+	assert.Equal(1, attempts)
+
+	r = newStore(flaky(10, failure))
+	r.Backoff = func(int) time.Duration { return time.Hour }
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, _, _, err = r.Write(cctx, bytes.NewReader([]byte("block")), "dk", "dir", "file")
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	assert.Equal(1, attempts)
+}
+
+func TestRetryClassification(t *testing.T) {
+	assert := require.New(t)
+
+	err := errors.New("boom")
+
+	assert.False(IsRetryable(err))
+	assert.True(IsRetryable(Retryable(err)))
+	assert.False(IsRetryable(Permanent(err)))
+	assert.False(IsRetryable(context.Canceled))
+	assert.ErrorIs(Permanent(err), err)
+
+	assert.False(IsRetryable(&os.PathError{Op: "open", Path: "/backup", Err: os.ErrPermission}))
+	assert.True(IsRetryable(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}))
+	assert.True(IsRetryable(&url.Error{Op: "Post", URL: "http://localhost", Err: os.ErrDeadlineExceeded}))
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	assert := require.New(t)
+
+	b := ExponentialBackoff(time.Second, 10*time.Second)
+
+	for retry, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 5: 10 * time.Second, 100: 10 * time.Second} {
+		d := b(retry)
+		assert.LessOrEqual(d, expected)
+		assert.GreaterOrEqual(d, expected*8/10)
+	}
+}
@@ -0,0 +1,295 @@
+package jetcapture
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	DefaultSFTPMaxIdle = 2
+	DefaultSFTPTimeout = 30 * time.Second
+)
+
+var (
+	_ BlockStore[string] = &SFTPBlockStore[string]{}
+)
+
+// SFTPConfig configures the connection to an SFTP server. Only key based authentication is supported.
+type SFTPConfig struct {
+	Addr            string              // host:port of the server
+	User            string              // remote user name
+	Signer          ssh.Signer          // private key, see LoadSSHPrivateKey
+	HostKeyCallback ssh.HostKeyCallback // required. e.g. `knownhosts.New(...)` or `ssh.FixedHostKey(...)`
+	Timeout         time.Duration       // connection timeout, defaults to DefaultSFTPTimeout
+	MaxIdle         int                 // number of idle connections kept for reuse, defaults to DefaultSFTPMaxIdle
+}
+
+// LoadSSHPrivateKey reads a PEM encoded private key (e.g. `~/.ssh/id_ed25519`). The passphrase may be nil.
+func LoadSSHPrivateKey(path string, passphrase []byte) (ssh.Signer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if passphrase != nil {
+		return ssh.ParsePrivateKeyWithPassphrase(b, passphrase)
+	}
+
+	return ssh.ParsePrivateKey(b)
+}
+
+type sftpConn struct {
+	ssh  *ssh.Client
+	sftp *sftp.Client
+}
+
+func (c *sftpConn) Close() error {
+	return errors.Join(c.sftp.Close(), c.ssh.Close())
+}
+
+// SFTPBlockStore uploads blocks over SFTP. Blocks are uploaded to a temporary name and renamed once complete, so a
+// partially uploaded block is never visible under its final name. Connections are reused across blocks, after checking
+// they still work.
+type SFTPBlockStore[K DestKey] struct {
+	// Resolver returns the remote base directory for the DestKey, like `LocalFSStore.Resolver`
+	Resolver func(destKey K) (string, error)
+
+	cfg       SFTPConfig
+	sshConfig *ssh.ClientConfig
+
+	idle   []*sftpConn
+	idleMu sync.Mutex
+}
+
+func NewSFTPBlockStore[K DestKey](cfg SFTPConfig, resolver func(destKey K) (string, error)) (*SFTPBlockStore[K], error) {
+	if cfg.Addr == _EMPTY_ {
+		return nil, errors.New("address not set")
+	}
+
+	if cfg.Signer == nil {
+		return nil, errors.New("signer not set")
+	}
+
+	if cfg.HostKeyCallback == nil {
+		return nil, errors.New("host key callback not set")
+	}
+
+	if resolver == nil {
+		return nil, errors.New("resolver not set")
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultSFTPTimeout
+	}
+
+	if cfg.MaxIdle == 0 {
+		cfg.MaxIdle = DefaultSFTPMaxIdle
+	}
+
+	return &SFTPBlockStore[K]{
+		Resolver: resolver,
+		cfg:      cfg,
+		sshConfig: &ssh.ClientConfig{
+			User:            cfg.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(cfg.Signer)},
+			HostKeyCallback: cfg.HostKeyCallback,
+			Timeout:         cfg.Timeout,
+		},
+	}, nil
+}
+
+func (s *SFTPBlockStore[K]) dial(ctx context.Context) (*sftpConn, error) {
+	d := net.Dialer{Timeout: s.cfg.Timeout}
+
+	conn, err := d.DialContext(ctx, "tcp", s.cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the handshake only has the connection timeout, so close the connection to abort it once ctx is done
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+
+	c, err := s.handshake(conn)
+	if !stop() {
+		if err == nil {
+			_ = c.Close()
+		}
+		return nil, ctx.Err()
+	}
+
+	return c, err
+}
+
+func (s *SFTPBlockStore[K]) handshake(conn net.Conn) (*sftpConn, error) {
+	sc, chans, reqs, err := ssh.NewClientConn(conn, s.cfg.Addr, s.sshConfig)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	client := ssh.NewClient(sc, chans, reqs)
+
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	return &sftpConn{ssh: client, sftp: sftpClient}, nil
+}
+
+// get returns an idle connection that still works, or dials a new one
+func (s *SFTPBlockStore[K]) get(ctx context.Context) (*sftpConn, error) {
+	for {
+		s.idleMu.Lock()
+
+		n := len(s.idle)
+		if n == 0 {
+			s.idleMu.Unlock()
+			return s.dial(ctx)
+		}
+
+		c := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.idleMu.Unlock()
+
+		// servers close connections that were idle for a while, which would otherwise only show once the upload fails
+		stop := context.AfterFunc(ctx, func() { _ = c.Close() })
+
+		_, err := c.sftp.Getwd()
+		if !stop() {
+			return nil, ctx.Err()
+		}
+
+		if err == nil {
+			return c, nil
+		}
+
+		log.Debugw("dropping broken sftp connection", "addr", s.cfg.Addr, "error", err)
+		_ = c.Close()
+	}
+}
+
+// put returns a healthy connection to the idle pool
+func (s *SFTPBlockStore[K]) put(c *sftpConn) {
+	s.idleMu.Lock()
+	defer s.idleMu.Unlock()
+
+	if len(s.idle) >= s.cfg.MaxIdle {
+		_ = c.Close()
+		return
+	}
+
+	s.idle = append(s.idle, c)
+}
+
+// Close closes all idle connections
+func (s *SFTPBlockStore[K]) Close() error {
+	s.idleMu.Lock()
+	defer s.idleMu.Unlock()
+
+	var errs []error
+	for _, c := range s.idle {
+		errs = append(errs, c.Close())
+	}
+
+	s.idle = nil
+
+	return errors.Join(errs...)
+}
+
+func (s *SFTPBlockStore[K]) Write(ctx context.Context, block io.Reader, destKey K, dir, fileName string) (string, int64, time.Duration, error) {
+	start := time.Now()
+
+	base, err := s.Resolver(destKey)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	remoteDir := path.Join(base, dir)
+	p := path.Join(remoteDir, fileName)
+
+	c, err := s.get(ctx)
+	if err != nil {
+		return p, 0, time.Since(start), err
+	}
+
+	log.Debugf("writing block to sftp://%s%s", s.cfg.Addr, p)
+
+	// sftp requests don't take a context, so close the connection to abort the upload once ctx is done
+	stop := context.AfterFunc(ctx, func() { _ = c.Close() })
+
+	n, err := s.upload(c.sftp, block, remoteDir, fileName)
+	if !stop() {
+		if err != nil {
+			err = fmt.Errorf("%w: %v", ctx.Err(), err)
+		}
+		return p, n, time.Since(start), err
+	}
+
+	if err != nil {
+		// the connection may be broken, so don't reuse it
+		_ = c.Close()
+		return p, n, time.Since(start), err
+	}
+
+	s.put(c)
+
+	return p, n, time.Since(start), nil
+}
+
+func (s *SFTPBlockStore[K]) upload(client *sftp.Client, block io.Reader, remoteDir, fileName string) (n int64, err error) {
+	if err := client.MkdirAll(remoteDir); err != nil {
+		return 0, fmt.Errorf("mkdir %s: %w", remoteDir, err)
+	}
+
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return 0, err
+	}
+
+	tmp := path.Join(remoteDir, "."+fileName+".tmp-"+hex.EncodeToString(suffix))
+	p := path.Join(remoteDir, fileName)
+
+	f, err := client.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = client.Remove(tmp)
+		}
+	}()
+
+	if n, err = f.ReadFrom(block); err != nil {
+		_ = f.Close()
+		return n, err
+	}
+
+	if err = f.Close(); err != nil {
+		return n, err
+	}
+
+	// prefer the OpenSSH extension which atomically replaces an existing file, like rename(2)
+	if err = client.PosixRename(tmp, p); err != nil {
+		var status *sftp.StatusError
+		if errors.As(err, &status) && status.FxCode() == sftp.ErrSSHFxOpUnsupported {
+			err = client.Rename(tmp, p)
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,223 @@
+package jetcapture
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// runSFTPServer starts an in-process SSH server with the sftp subsystem, only accepting the client key
+func runSFTPServer(t *testing.T, clientKey ssh.PublicKey) (addr string, hostKey ssh.PublicKey, connections *int32) {
+	assert := require.New(t)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(err)
+
+	hostSigner, err := ssh.NewSignerFromKey(priv)
+	assert.Nil(err)
+
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	cfg.AddHostKey(hostSigner)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	connections = new(int32)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			atomic.AddInt32(connections, 1)
+
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+				if err != nil {
+					return
+				}
+
+				go ssh.DiscardRequests(reqs)
+
+				for nc := range chans {
+					ch, requests, err := nc.Accept()
+					if err != nil {
+						return
+					}
+
+					go func() {
+						for req := range requests {
+							ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+							_ = req.Reply(ok, nil)
+
+							if ok {
+								server, err := sftp.NewServer(ch)
+								if err == nil {
+									_ = server.Serve()
+								}
+								_ = ch.Close()
+							}
+						}
+					}()
+				}
+			}()
+		}
+	}()
+
+	return l.Addr().String(), hostSigner.PublicKey(), connections
+}
+
+func TestSFTPBlockStore(t *testing.T) {
+	assert := require.New(t)
+
+	_, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(err)
+
+	signer, err := ssh.NewSignerFromKey(clientPriv)
+	assert.Nil(err)
+
+	addr, hostKey, connections := runSFTPServer(t, signer.PublicKey())
+
+	root := t.TempDir()
+
+	store, err := NewSFTPBlockStore[string](SFTPConfig{
+		Addr:            addr,
+		User:            "capture",
+		Signer:          signer,
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+	}, func(dk string) (string, error) {
+		return filepath.Join(root, dk), nil
+	})
+	assert.Nil(err)
+
+	defer store.Close()
+
+	ctx := context.Background()
+
+	for i, content := range []string{"hello sftp", "replaced"} {
+		p, n, _, err := store.Write(ctx, bytes.NewReader([]byte(content)), "k1", "2024/01/02", "backup-1.csv")
+		assert.Nil(err, i)
+		assert.Equal(filepath.Join(root, "k1", "2024/01/02", "backup-1.csv"), p)
+		assert.EqualValues(len(content), n)
+
+		b, err := os.ReadFile(p)
+		assert.Nil(err)
+		assert.Equal(content, string(b))
+	}
+
+	// the connection is reused
+	assert.EqualValues(1, atomic.LoadInt32(connections))
+
+	// a connection the server closed while idle is replaced
+	store.idleMu.Lock()
+	_ = store.idle[0].ssh.Close()
+	store.idleMu.Unlock()
+
+	_, _, _, err = store.Write(ctx, bytes.NewReader([]byte("replaced")), "k1", "2024/01/02", "backup-1.csv")
+	assert.Nil(err)
+	assert.EqualValues(2, atomic.LoadInt32(connections))
+
+	// no temp files are left behind
+	entries, err := os.ReadDir(filepath.Join(root, "k1", "2024/01/02"))
+	assert.Nil(err)
+	assert.Len(entries, 1)
+
+	// wrong host key
+	_, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(err)
+
+	otherSigner, err := ssh.NewSignerFromKey(otherPriv)
+	assert.Nil(err)
+
+	untrusted, err := NewSFTPBlockStore[string](SFTPConfig{
+		Addr:            addr,
+		User:            "capture",
+		Signer:          signer,
+		HostKeyCallback: ssh.FixedHostKey(otherSigner.PublicKey()),
+	}, func(dk string) (string, error) {
+		return root, nil
+	})
+	assert.Nil(err)
+
+	_, _, _, err = untrusted.Write(ctx, bytes.NewReader([]byte("nope")), "k1", "dir", "file")
+	assert.NotNil(err)
+
+	// unknown client key
+	unknown, err := NewSFTPBlockStore[string](SFTPConfig{
+		Addr:            addr,
+		User:            "capture",
+		Signer:          otherSigner,
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+	}, func(dk string) (string, error) {
+		return root, nil
+	})
+	assert.Nil(err)
+
+	_, _, _, err = unknown.Write(ctx, bytes.NewReader([]byte("nope")), "k1", "dir", "file")
+	assert.NotNil(err)
+}
+
+func TestSFTPBlockStoreContext(t *testing.T) {
+	assert := require.New(t)
+
+	_, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(err)
+
+	signer, err := ssh.NewSignerFromKey(clientPriv)
+	assert.Nil(err)
+
+	// a server that accepts connections, but never completes the handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { _ = conn.Close() })
+		}
+	}()
+
+	store, err := NewSFTPBlockStore[string](SFTPConfig{
+		Addr:            l.Addr().String(),
+		User:            "capture",
+		Signer:          signer,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}, func(dk string) (string, error) {
+		return "/", nil
+	})
+	assert.Nil(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	_, _, _, err = store.Write(ctx, bytes.NewReader([]byte("nope")), "k1", "dir", "file")
+	assert.ErrorIs(err, context.DeadlineExceeded)
+	assert.Less(time.Since(start), DefaultSFTPTimeout)
+}
@@ -0,0 +1,53 @@
+package jetcapture
+
+// Stats is a point in time snapshot of the capture counters
+type Stats struct {
+	Fetched int // messages fetched from the consumer
+	Acked   int // messages acked after their block was stored
+	Dropped int // messages dropped by `Options.Filter`
+	Routed  int // messages routed to another DestKey by `Options.Filter`
+	Failed  int // messages that failed decoding or transforming. these are not acked
+	Late    int // messages for intervals that already expired (see `Options.LatePolicy`)
+	Nacked  int // messages nacked for redelivery, because the spool filled up while handling their batch
+
+	Duplicates    int // redelivered messages already written to their block (see `Options.ExactlyOnce`)
+	TimeFallbacks int // messages without a usable time from `Options.TimeExtractor` (see `Options.TimeFallback`)
+
+	MemoryBytes   int64 // current size of the in-memory block buffers
+	MemoryFlushes int   // blocks spilled to disk or stored early to stay under `Options.MaxMemoryBytes`
+	SpoolBytes    int64 // current size of the temp files of blocks
+	SpoolFlushes  int   // blocks stored early to stay within `Options.MaxSpoolBytes` and `Options.MinFreeSpoolBytes`
+	FetchBatch    int   // size of the last fetch request (see `Options.AdaptiveFetch`)
+	FetchPaused   bool  // fetching is paused because the spool is still full after storing all blocks (see Health)
+
+	TransformErrors []int // errors per transform, indexed like `Options.Transforms`
+}
+
+// Stats returns a snapshot of the capture counters. It is safe to call while the capture is running.
+func (c *Capture[P, K]) Stats() Stats {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	stats := c.stats
+	stats.MemoryBytes = c.memory.Load()
+	stats.SpoolBytes = c.spool.Load()
+	stats.TransformErrors = append([]int(nil), c.stats.TransformErrors...)
+
+	return stats
+}
+
+// Health returns why the capture isn't making progress (e.g. fetching is paused because the spool is full), or nil.
+// It is safe to call while the capture is running.
+func (c *Capture[P, K]) Health() error {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	return c.pausedErr
+}
+
+func (c *Capture[P, K]) updateStats(fn func(s *Stats)) {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	fn(&c.stats)
+}
@@ -1,10 +1,18 @@
 package jetcapture
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
+	"mime"
 	"os"
 	"path"
+	"strings"
 	"time"
 )
 
@@ -15,12 +23,41 @@ type BlockStore[K DestKey] interface {
 	Write(ctx context.Context, block io.Reader, destKey K, dir, fileName string) (string, int64, time.Duration, error)
 }
 
+// BlockRewriter is implemented by stores that can merge late messages into a block they already stored (see
+// LateRewrite). The block only holds the late messages, and fileName is the name of the stored block.
+type BlockRewriter[K DestKey] interface {
+	Rewrite(ctx context.Context, block io.Reader, destKey K, dir, fileName string) (string, int64, time.Duration, error)
+}
+
 var (
-	_ BlockStore[string] = &LocalFSStore[string]{}
+	_ BlockStore[string]    = &LocalFSStore[string]{}
+	_ BlockRewriter[string] = &LocalFSStore[string]{}
+)
+
+const (
+	DefaultFileMode       os.FileMode = 0666 // before umask, same as `os.Create`
+	DefaultDirMode        os.FileMode = 0755
+	DefaultChecksumSuffix             = ".sum"
 )
 
+// LocalFSStore writes blocks to the local file system. Blocks are written to a temporary file in the destination
+// directory which is synced and then renamed, so a partially written block is never visible under its final name.
 type LocalFSStore[K DestKey] struct {
 	Resolver func(destKey K) (string, error)
+
+	FileMode  os.FileMode // defaults to DefaultFileMode
+	DirMode   os.FileMode // defaults to DefaultDirMode
+	NoClobber bool        // fail with `os.ErrExist` instead of replacing an existing file
+
+	// ReplaceChanged makes NoClobber replace an existing block whose contents differ, and only fail with `os.ErrExist`
+	// if they are the same. Meant for `Options.ExactlyOnce`, where a redelivered block can hold more messages than the
+	// block a previous run stored under the same name.
+	ReplaceChanged bool
+
+	// Checksum optionally computes a checksum of the block while it is copied. It is written to a sidecar file named
+	// after the block plus ChecksumSuffix (defaults to DefaultChecksumSuffix) in `sha256sum` compatible format.
+	Checksum       func() hash.Hash
+	ChecksumSuffix string
 }
 
 func (f *LocalFSStore[K]) Write(_ context.Context, block io.Reader, destKey K, dir, fileName string) (string, int64, time.Duration, error) {
@@ -33,7 +70,12 @@ func (f *LocalFSStore[K]) Write(_ context.Context, block io.Reader, destKey K, d
 
 	p = path.Join(p, dir)
 
-	if err := os.MkdirAll(p, 0755); err != nil {
+	dirMode := f.DirMode
+	if dirMode == 0 {
+		dirMode = DefaultDirMode
+	}
+
+	if err := os.MkdirAll(p, dirMode); err != nil {
 		return "", 0, 0, err
 	}
 
@@ -41,19 +83,335 @@ func (f *LocalFSStore[K]) Write(_ context.Context, block io.Reader, destKey K, d
 
 	log.Debugf("writing block to %s", p)
 
-	fout, err := os.Create(p)
+	n, err := f.store(p, block, f.NoClobber)
+
+	return p, n, time.Since(start), err
+}
+
+// Rewrite merges the late messages into the stored NDJSON or CSV block, replacing it atomically. Encrypted blocks
+// can't be rewritten.
+func (f *LocalFSStore[K]) Rewrite(_ context.Context, block io.Reader, destKey K, dir, fileName string) (string, int64, time.Duration, error) {
+	start := time.Now()
+
+	p, err := f.Resolver(destKey)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	p = path.Join(p, dir, fileName)
+
+	suffix, compression, encrypted := ParseBlockFileName(fileName)
+	if encrypted {
+		return p, 0, 0, Permanent(errors.New("encrypted blocks can't be rewritten"))
+	}
+
+	// fail before touching anything if the format can't be merged
+	if _, err := newBlockMerger(suffix, io.Discard, csvRepeatedHeader); err != nil {
+		return p, 0, 0, Permanent(err)
+	}
+
+	stored, err := os.Open(p)
 	if err != nil {
 		return p, 0, 0, err
 	}
 
-	defer fout.Close()
+	defer stored.Close()
+
+	log.Debugf("rewriting block %s", p)
+
+	pr, pw := io.Pipe()
+	merged := make(chan struct{})
+
+	go func() {
+		defer close(merged)
+
+		pw.CloseWithError(func() error {
+			wr := newCompressingWriter(pw, compression)
+
+			// the CSVWriter header is optional, so only drop the late block's first row if it repeats the header
+			merger, _ := newBlockMerger(suffix, wr, csvRepeatedHeader)
+
+			for _, r := range []io.Reader{stored, block} {
+				plain, err := OpenBlock(r, fileName, nil)
+				if err != nil {
+					return err
+				}
+				if _, err := merger.merge(plain); err != nil {
+					return err
+				}
+			}
 
-	n, err := io.Copy(fout, block)
+			if err := merger.flush(); err != nil {
+				return err
+			}
+
+			return wr.Close()
+		}())
+	}()
+
+	n, err := f.store(p, pr, false)
+
+	// unblock the merging goroutine if storing failed early, and wait for it to let go of the blocks
+	_ = pr.CloseWithError(err)
+	<-merged
+
+	return p, n, time.Since(start), err
+}
+
+// store writes the block and its optional checksum file
+func (f *LocalFSStore[K]) store(p string, block io.Reader, noClobber bool) (int64, error) {
+	var checksum hash.Hash
+
+	if f.Checksum != nil {
+		checksum = f.Checksum()
+	}
+
+	n, err := f.writeAtomic(p, noClobber, func(fout io.Writer) (int64, error) {
+		var wr io.Writer = fout
+		if checksum != nil {
+			wr = io.MultiWriter(fout, checksum)
+		}
+		return io.Copy(wr, block)
+	})
 	if err != nil {
-		return p, n, time.Since(start), err
+		return n, err
+	}
+
+	if checksum != nil {
+		suffix := f.ChecksumSuffix
+		if suffix == _EMPTY_ {
+			suffix = DefaultChecksumSuffix
+		}
+
+		line := fmt.Sprintf("%s  %s\n", hex.EncodeToString(checksum.Sum(nil)), path.Base(p))
+
+		if _, err := f.writeAtomic(p+suffix, false, func(fout io.Writer) (int64, error) {
+			n, err := io.WriteString(fout, line)
+			return int64(n), err
+		}); err != nil {
+			if noClobber {
+				// the block is in place, so writing it again would only fail with `os.ErrExist`
+				log.Errorw("unable to write checksum file", "path", p+suffix, "error", err)
+				return n, nil
+			}
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// writeAtomic writes to a temporary file next to the destination, syncs it, and then moves it into place and syncs
+// the directory. With noClobber, the file is hard linked into place, which like `O_EXCL` fails if the destination
+// exists. Filesystems without hard links fall back to creating the destination with `O_EXCL` and copying into it.
+func (f *LocalFSStore[K]) writeAtomic(p string, noClobber bool, write func(io.Writer) (int64, error)) (n int64, err error) {
+	fileMode := f.FileMode
+	if fileMode == 0 {
+		fileMode = DefaultFileMode
+	}
+
+	dir, name := path.Split(p)
+
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return 0, err
+	}
+
+	// the leading dot and trailing suffix keep the temp file from matching any glob downstream tools might use
+	tmp := path.Join(dir, "."+name+".tmp-"+hex.EncodeToString(suffix))
+
+	fout, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fileMode)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		_ = fout.Close()
+		// after a successful rename this is a nop
+		_ = os.Remove(tmp)
+	}()
+
+	if n, err = write(fout); err != nil {
+		return n, err
+	}
+
+	if err = fout.Sync(); err != nil {
+		return n, err
+	}
+
+	if err = fout.Close(); err != nil {
+		return n, err
+	}
+
+	if noClobber {
+		if err = os.Link(tmp, p); err != nil && !errors.Is(err, os.ErrExist) {
+			err = copyExclusive(tmp, p, fileMode)
+		}
+		if errors.Is(err, os.ErrExist) && f.ReplaceChanged {
+			same, serr := sameContents(tmp, p)
+			if serr != nil {
+				return n, serr
+			}
+			if !same {
+				log.Warnw("replacing existing file with different contents", "path", p)
+				err = os.Rename(tmp, p)
+			}
+		}
+		if errors.Is(err, os.ErrExist) {
+			// retrying won't help
+			return n, Permanent(err)
+		}
+		if err != nil {
+			return n, err
+		}
+	} else if err = os.Rename(tmp, p); err != nil {
+		return n, err
+	}
+
+	return n, syncDir(dir)
+}
+
+// copyExclusive copies src to a new file dst, failing with `os.ErrExist` if dst exists. Unlike a hard link, a crash
+// while copying leaves a partial dst behind.
+func copyExclusive(src, dst string, fileMode os.FileMode) (err error) {
+	fin, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer fin.Close()
+
+	fout, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fileMode)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = fout.Close()
+		if err != nil {
+			_ = os.Remove(dst)
+		}
+	}()
+
+	if _, err = io.Copy(fout, fin); err != nil {
+		return err
+	}
+
+	if err = fout.Sync(); err != nil {
+		return err
+	}
+
+	return fout.Close()
+}
+
+// sameContents reports whether the files a and b have the same contents
+func sameContents(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+
+	defer fa.Close()
+
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+
+	defer fb.Close()
+
+	bufA, bufB := make([]byte, 32*1024), make([]byte, 32*1024)
+
+	for {
+		na, erra := io.ReadFull(fa, bufA)
+		nb, errb := io.ReadFull(fb, bufB)
+
+		if !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+
+		switch {
+		case erra == io.EOF || erra == io.ErrUnexpectedEOF:
+			// both ended, as the last chunks were equal
+			return errb == io.EOF || errb == io.ErrUnexpectedEOF, nil
+		case erra != nil:
+			return false, erra
+		case errb != nil && errb != io.EOF && errb != io.ErrUnexpectedEOF:
+			return false, errb
+		}
+	}
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return err
+	}
+
+	return d.Close()
+}
+
+// rewindable returns the block as an `io.ReadSeeker` positioned at the start. Blocks passed in by jetcapture are
+// seekable, anything else is read into memory.
+func rewindable(block io.Reader) (io.ReadSeeker, error) {
+	if rs, ok := block.(io.ReadSeeker); ok {
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return rs, nil
+	}
+
+	b, err := io.ReadAll(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if m, ok := ManifestOf(block); ok {
+		return &manifestReader{ReadSeeker: bytes.NewReader(b), manifest: m}, nil
+	}
+
+	return bytes.NewReader(b), nil
+}
+
+// blockContentHeaders derives the HTTP `Content-Type` and `Content-Encoding` of a block from its file name (i.e. the
+// capture's `Suffix`) and manifest (i.e. `Compression` and `Encryption`)
+func blockContentHeaders(block io.Reader, fileName string) (contentType, contentEncoding string) {
+	m, ok := ManifestOf(block)
+	if !ok {
+		// without a manifest the file name is all there is to go on
+		m.Encrypted = strings.HasSuffix(fileName, ".enc")
+		switch {
+		case strings.HasSuffix(fileName, ".gz"):
+			m.Compression = GZip
+		case strings.HasSuffix(fileName, ".snappy"):
+			m.Compression = Snappy
+		}
+	}
+
+	if m.Encrypted {
+		return "application/octet-stream", _EMPTY_
+	}
+
+	base := strings.TrimSuffix(strings.TrimSuffix(fileName, ".gz"), ".snappy")
+
+	switch ext := path.Ext(base); ext {
+	case ".json", ".ndjson", ".jsonl":
+		contentType = "application/x-ndjson"
+	case ".csv":
+		contentType = "text/csv"
+	default:
+		if contentType = mime.TypeByExtension(ext); contentType == _EMPTY_ {
+			contentType = "application/octet-stream"
+		}
 	}
 
-	return p, n, time.Since(start), fout.Close()
+	return contentType, m.ContentEncoding()
 }
 
 func SingleDirStore[K DestKey](path string) BlockStore[K] {
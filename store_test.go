@@ -3,10 +3,15 @@ package jetcapture
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/stretchr/testify/require"
@@ -53,3 +58,139 @@ func TestFSStore(t *testing.T) {
 
 	assert.EqualValues(testString, b)
 }
+
+func TestFSStoreAtomic(t *testing.T) {
+	assert := require.New(t)
+
+	tmp := t.TempDir()
+
+	s := &LocalFSStore[string]{
+		Resolver: func(dk string) (string, error) {
+			return filepath.Join(tmp, dk), nil
+		},
+		FileMode:  0600,
+		DirMode:   0700,
+		NoClobber: true,
+		Checksum:  sha256.New,
+	}
+
+	ctx := context.Background()
+
+	p, n, _, err := s.Write(ctx, strings.NewReader("hello"), "k1", "foo", "block.csv")
+	assert.Nil(err)
+	assert.EqualValues(5, n)
+
+	fi, err := os.Stat(p)
+	assert.Nil(err)
+	assert.Equal(os.FileMode(0600), fi.Mode().Perm())
+
+	fi, err = os.Stat(filepath.Join(tmp, "k1", "foo"))
+	assert.Nil(err)
+	assert.Equal(os.FileMode(0700), fi.Mode().Perm())
+
+	sum, err := os.ReadFile(p + DefaultChecksumSuffix)
+	assert.Nil(err)
+	assert.Equal("2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824  block.csv\n", string(sum))
+
+	// no clobber
+	_, _, _, err = s.Write(ctx, strings.NewReader("world"), "k1", "foo", "block.csv")
+	assert.ErrorIs(err, os.ErrExist)
+	assert.False(IsRetryable(err))
+
+	b, err := os.ReadFile(p)
+	assert.Nil(err)
+	assert.Equal("hello", string(b))
+
+	// with ReplaceChanged, only the same contents fail
+	s.ReplaceChanged = true
+	_, _, _, err = s.Write(ctx, strings.NewReader("hello"), "k1", "foo", "block.csv")
+	assert.ErrorIs(err, os.ErrExist)
+
+	_, _, _, err = s.Write(ctx, strings.NewReader("hello world"), "k1", "foo", "block.csv")
+	assert.Nil(err)
+
+	b, err = os.ReadFile(p)
+	assert.Nil(err)
+	assert.Equal("hello world", string(b))
+	s.ReplaceChanged = false
+
+	// overwrite
+	s.NoClobber = false
+	_, _, _, err = s.Write(ctx, strings.NewReader("world"), "k1", "foo", "block.csv")
+	assert.Nil(err)
+
+	b, err = os.ReadFile(p)
+	assert.Nil(err)
+	assert.Equal("world", string(b))
+
+	// a failing copy leaves nothing behind
+	_, _, _, err = s.Write(ctx, io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("boom"))), "k1", "foo", "failed.csv")
+	assert.NotNil(err)
+
+	entries, err := os.ReadDir(filepath.Join(tmp, "k1", "foo"))
+	assert.Nil(err)
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.Equal([]string{"block.csv", "block.csv" + DefaultChecksumSuffix}, names)
+
+	// with no clobber, a block that is in place is stored even if its checksum file can't be written
+	s.NoClobber = true
+	assert.Nil(os.Mkdir(filepath.Join(tmp, "k1", "foo", "nosum.csv"+DefaultChecksumSuffix), 0700))
+
+	p, _, _, err = s.Write(ctx, strings.NewReader("hello"), "k1", "foo", "nosum.csv")
+	assert.Nil(err)
+
+	b, err = os.ReadFile(p)
+	assert.Nil(err)
+	assert.Equal("hello", string(b))
+
+	// the exclusive copy used without hard links
+	assert.ErrorIs(copyExclusive(p, p, 0600), os.ErrExist)
+	assert.Nil(copyExclusive(p, p+".copy", 0600))
+
+	b, err = os.ReadFile(p + ".copy")
+	assert.Nil(err)
+	assert.Equal("hello", string(b))
+}
+
+func TestFSStoreRewrite(t *testing.T) {
+	assert := require.New(t)
+
+	tmp := t.TempDir()
+
+	s := &LocalFSStore[string]{
+		Resolver: func(dk string) (string, error) {
+			return filepath.Join(tmp, dk), nil
+		},
+		NoClobber: true,
+		Checksum:  sha256.New,
+	}
+
+	ctx := context.Background()
+
+	_, _, _, err := s.Write(ctx, strings.NewReader("id,name\n1,a\n2,b\n"), "k1", "foo", "block.csv")
+	assert.Nil(err)
+
+	// the repeated header of the late block is dropped
+	p, n, _, err := s.Rewrite(ctx, strings.NewReader("id,name\n3,c\n"), "k1", "foo", "block.csv")
+	assert.Nil(err)
+	assert.EqualValues(20, n)
+
+	b, err := os.ReadFile(p)
+	assert.Nil(err)
+	assert.Equal("id,name\n1,a\n2,b\n3,c\n", string(b))
+
+	sum, err := os.ReadFile(p + DefaultChecksumSuffix)
+	assert.Nil(err)
+	assert.Equal(fmt.Sprintf("%x  block.csv\n", sha256.Sum256(b)), string(sum))
+
+	_, _, _, err = s.Rewrite(ctx, strings.NewReader("4,d\n"), "k1", "foo", "missing.csv")
+	assert.ErrorIs(err, os.ErrNotExist)
+
+	_, _, _, err = s.Rewrite(ctx, strings.NewReader("4,d\n"), "k1", "foo", "block.csv.enc")
+	assert.NotNil(err)
+	assert.False(IsRetryable(err))
+}
@@ -0,0 +1,96 @@
+package jetcapture
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// ErrNoTime is returned by a TimeExtractor when the message doesn't carry a time
+var ErrNoTime = errors.New("message time not found")
+
+// TimeExtractor returns the time a message belongs to (e.g. when the event happened), which decides the block it is
+// written to. Errors (including ErrNoTime) are handled according to `Options.TimeFallback`.
+type TimeExtractor[P Payload] func(msg *nats.Msg, md *nats.MsgMetadata, payload P) (time.Time, error)
+
+// TimeFallback decides what happens to messages without a usable time, i.e. the TimeExtractor failed or the time is
+// outside of `Options.MaxPastTime` or `Options.MaxFutureTime`
+type TimeFallback string
+
+const (
+	TimeFallbackStream TimeFallback = "stream" // use the stream timestamp instead (the default)
+	TimeFallbackDrop   TimeFallback = "drop"   // don't write the message. it is still acked along with its block
+	TimeFallbackFail   TimeFallback = "fail"   // fail the message like a decoding error. it is not acked
+)
+
+const (
+	TimeLayoutUnix      = "unix"      // seconds since the epoch, optionally with a fraction (e.g. `1704164400.25`)
+	TimeLayoutUnixMilli = "unixmilli" // milliseconds since the epoch
+)
+
+// StreamTime uses the JetStream timestamp of the message, which is what jetcapture does without a TimeExtractor
+func StreamTime[P Payload]() TimeExtractor[P] {
+	return func(_ *nats.Msg, md *nats.MsgMetadata, _ P) (time.Time, error) {
+		return md.Timestamp, nil
+	}
+}
+
+// ResolvedTime parses the value of a resolver (e.g. HeaderKey or JSONPointerKey) using the layout, which is either a
+// `time.Parse` layout, TimeLayoutUnix or TimeLayoutUnixMilli. An empty layout means `time.RFC3339Nano`.
+func ResolvedTime[P Payload](resolver KeyResolver[string], layout string) TimeExtractor[P] {
+	if layout == _EMPTY_ {
+		layout = time.RFC3339Nano
+	}
+
+	return func(msg *nats.Msg, _ *nats.MsgMetadata, _ P) (time.Time, error) {
+		v, err := resolver(msg)
+		if errors.Is(err, ErrKeyNotFound) {
+			return time.Time{}, fmt.Errorf("%w: %v", ErrNoTime, err)
+		}
+
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		return parseTimeValue(v, layout)
+	}
+}
+
+// HeaderTime parses the first value of the named header (e.g. `Event-Time`). See ResolvedTime for the layout.
+func HeaderTime[P Payload](name, layout string) TimeExtractor[P] {
+	return ResolvedTime[P](HeaderKey(name), layout)
+}
+
+// PayloadTime takes the time from the decoded payload. fn returns false if the payload has no time.
+func PayloadTime[P Payload](fn func(payload P) (time.Time, bool)) TimeExtractor[P] {
+	return func(_ *nats.Msg, _ *nats.MsgMetadata, payload P) (time.Time, error) {
+		if t, ok := fn(payload); ok && !t.IsZero() {
+			return t, nil
+		}
+		return time.Time{}, ErrNoTime
+	}
+}
+
+func parseTimeValue(v, layout string) (time.Time, error) {
+	switch layout {
+	case TimeLayoutUnix:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid unix time %q", v)
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	case TimeLayoutUnixMilli:
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid unix milli time %q", v)
+		}
+		return time.UnixMilli(ms), nil
+	default:
+		return time.Parse(layout, v)
+	}
+}
@@ -0,0 +1,60 @@
+package jetcapture
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeExtractors(t *testing.T) {
+	assert := require.New(t)
+
+	type P = map[string]any
+
+	md := &nats.MsgMetadata{Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+
+	msg := &nats.Msg{
+		Subject: "devices.d1",
+		Header:  nats.Header{},
+		Data:    []byte(`{"event":{"ts":1704164400250}}`),
+	}
+	msg.Header.Set("Event-Time", "2024-01-02T01:00:00Z")
+	msg.Header.Set("Event-Unix", "1704164400.5")
+
+	ts, err := StreamTime[P]()(msg, md, nil)
+	assert.Nil(err)
+	assert.Equal(md.Timestamp, ts)
+
+	ts, err = HeaderTime[P]("Event-Time", _EMPTY_)(msg, md, nil)
+	assert.Nil(err)
+	assert.True(ts.Equal(time.Date(2024, 1, 2, 1, 0, 0, 0, time.UTC)))
+
+	ts, err = HeaderTime[P]("Event-Unix", TimeLayoutUnix)(msg, md, nil)
+	assert.Nil(err)
+	assert.True(ts.Equal(time.Date(2024, 1, 2, 3, 0, 0, 5e8, time.UTC)))
+
+	ts, err = ResolvedTime[P](JSONPointerKey("/event/ts"), TimeLayoutUnixMilli)(msg, md, nil)
+	assert.Nil(err)
+	assert.True(ts.Equal(time.Date(2024, 1, 2, 3, 0, 0, 25e7, time.UTC)))
+
+	_, err = HeaderTime[P]("Missing", _EMPTY_)(msg, md, nil)
+	assert.ErrorIs(err, ErrNoTime)
+
+	_, err = HeaderTime[P]("Event-Unix", _EMPTY_)(msg, md, nil)
+	assert.NotNil(err)
+	assert.NotErrorIs(err, ErrNoTime)
+
+	payloadTime := PayloadTime(func(p P) (time.Time, bool) {
+		v, ok := p["ts"].(time.Time)
+		return v, ok
+	})
+
+	ts, err = payloadTime(msg, md, P{"ts": md.Timestamp.Add(-time.Hour)})
+	assert.Nil(err)
+	assert.Equal(md.Timestamp.Add(-time.Hour), ts)
+
+	_, err = payloadTime(msg, md, P{})
+	assert.ErrorIs(err, ErrNoTime)
+}
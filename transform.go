@@ -0,0 +1,153 @@
+package jetcapture
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// Transform modifies a decoded payload before it is written to a block (e.g. to redact PII). Returning an error fails
+// the message the same way a decoding error does.
+type Transform[P Payload] func(payload P) (P, error)
+
+// RemoveHeaders deletes the named headers
+func RemoveHeaders(names ...string) Transform[*NatsMessage] {
+	return func(m *NatsMessage) (*NatsMessage, error) {
+		for _, name := range names {
+			delete(m.Header, name)
+		}
+		return m, nil
+	}
+}
+
+// RedactHeaders replaces all values of the named headers with the replacement
+func RedactHeaders(replacement string, names ...string) Transform[*NatsMessage] {
+	return mapHeaders(names, func(string) string {
+		return replacement
+	})
+}
+
+// HMACHeaders replaces all values of the named headers with their hex encoded HMAC-SHA256 using the key. Equal values
+// hash to equal outputs, so the headers can still be correlated without revealing the original.
+func HMACHeaders(key []byte, names ...string) Transform[*NatsMessage] {
+	return mapHeaders(names, func(v string) string {
+		return hmacHex(key, v)
+	})
+}
+
+// RedactJSONFields replaces the values found at the JSON pointers (see JSONPointerKey) within the message data with
+// the replacement. Missing fields are ignored.
+func RedactJSONFields(replacement any, pointers ...string) Transform[*NatsMessage] {
+	return mapJSONFields(pointers, func(any) (any, error) {
+		return replacement, nil
+	})
+}
+
+// HMACJSONFields replaces the scalar values found at the JSON pointers (see JSONPointerKey) within the message data
+// with their hex encoded HMAC-SHA256 using the key. Missing fields are ignored.
+func HMACJSONFields(key []byte, pointers ...string) Transform[*NatsMessage] {
+	return mapJSONFields(pointers, func(v any) (any, error) {
+		switch t := v.(type) {
+		case string:
+			return hmacHex(key, t), nil
+		case json.Number:
+			return hmacHex(key, t.String()), nil
+		case bool:
+			return hmacHex(key, strconv.FormatBool(t)), nil
+		case nil:
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("unable to hash non-scalar value of type %T", v)
+		}
+	})
+}
+
+func hmacHex(key []byte, v string) string {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(v))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func mapHeaders(names []string, fn func(string) string) Transform[*NatsMessage] {
+	return func(m *NatsMessage) (*NatsMessage, error) {
+		for _, name := range names {
+			values, ok := m.Header[name]
+			if !ok {
+				continue
+			}
+
+			// the header values may be shared with the original message so never modify them in place
+			mapped := make([]string, len(values))
+			for i, v := range values {
+				mapped[i] = fn(v)
+			}
+
+			m.Header[name] = mapped
+		}
+		return m, nil
+	}
+}
+
+func mapJSONFields(pointers []string, fn func(any) (any, error)) Transform[*NatsMessage] {
+	parsed := make([][]string, len(pointers))
+	for i, p := range pointers {
+		parsed[i] = parseJSONPointer(p)
+	}
+
+	return func(m *NatsMessage) (*NatsMessage, error) {
+		dec := json.NewDecoder(bytes.NewReader(m.Data))
+		dec.UseNumber()
+
+		var doc any
+		if err := dec.Decode(&doc); err != nil {
+			return m, err
+		}
+
+		for _, tokens := range parsed {
+			if len(tokens) == 0 {
+				continue
+			}
+
+			parent, ok := lookupJSONPointer(doc, tokens[:len(tokens)-1])
+			if !ok {
+				continue
+			}
+
+			last := tokens[len(tokens)-1]
+
+			switch node := parent.(type) {
+			case map[string]any:
+				v, ok := node[last]
+				if !ok {
+					continue
+				}
+				mapped, err := fn(v)
+				if err != nil {
+					return m, err
+				}
+				node[last] = mapped
+			case []any:
+				i, err := strconv.Atoi(last)
+				if err != nil || i < 0 || i >= len(node) {
+					continue
+				}
+				if node[i], err = fn(node[i]); err != nil {
+					return m, err
+				}
+			}
+		}
+
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return m, err
+		}
+
+		m.Data = data
+
+		return m, nil
+	}
+}
@@ -0,0 +1,96 @@
+package jetcapture
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNatsMessageTransforms(t *testing.T) {
+	assert := require.New(t)
+
+	original := []string{"alice@example.com"}
+
+	m := &NatsMessage{
+		Subject: "orders.a",
+		Header: map[string][]string{
+			"Email":    original,
+			"Customer": {"42"},
+			"Trace":    {"abc"},
+		},
+		Data: []byte(`{"email": "alice@example.com", "customer": {"id": 42}, "items": [{"sku": "x"}], "amount": 1.10}`),
+	}
+
+	key := []byte("secret")
+
+	var err error
+
+	for _, tr := range []Transform[*NatsMessage]{
+		RemoveHeaders("Trace"),
+		RedactHeaders("***", "Email"),
+		HMACHeaders(key, "Customer"),
+		RedactJSONFields("***", "/email", "/missing/field", "items.0.sku"),
+		HMACJSONFields(key, "/customer/id"),
+	} {
+		m, err = tr(m)
+		assert.Nil(err)
+	}
+
+	assert.Equal(map[string][]string{
+		"Email":    {"***"},
+		"Customer": {hmacHex(key, "42")},
+	}, m.Header)
+
+	// the original header values must not be modified
+	assert.Equal("alice@example.com", original[0])
+
+	assert.JSONEq(
+		`{"email": "***", "customer": {"id": "`+hmacHex(key, "42")+`"}, "items": [{"sku": "***"}], "amount": 1.10}`,
+		string(m.Data),
+	)
+	assert.Contains(string(m.Data), `"amount":1.10`)
+
+	_, err = HMACJSONFields(key, "/customer")(m)
+	assert.NotNil(err)
+
+	_, err = RedactJSONFields("***", "/email")(&NatsMessage{Data: []byte("not json")})
+	assert.NotNil(err)
+}
+
+func TestCaptureTransformErrors(t *testing.T) {
+	assert := require.New(t)
+
+	c := New(Options[int, string]{
+		Transforms: []Transform[int]{
+			func(p int) (int, error) { return p + 1, nil },
+			func(p int) (int, error) {
+				if p > 10 {
+					return p, errors.New("too big")
+				}
+				return p * 2, nil
+			},
+			func(p int) (int, error) {
+				if p == 4 {
+					panic("boom")
+				}
+				return p, nil
+			},
+		},
+	})
+
+	p, err := c.transform(2)
+	assert.Nil(err)
+	assert.Equal(6, p)
+
+	_, err = c.transform(20)
+	assert.NotNil(err)
+
+	_, err = c.transform(1)
+	assert.NotNil(err)
+
+	_, err = c.transform(30)
+	assert.NotNil(err)
+
+	assert.Equal([]int{0, 2, 1}, c.Stats().TransformErrors)
+}
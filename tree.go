@@ -0,0 +1,137 @@
+package jetcapture
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StoredBlock is a block file found in a directory tree written by LocalFSStore
+type StoredBlock struct {
+	Path      string    // path of the block file
+	DestDir   string    // directory of the destination key relative to the root ("." if the root is the destination)
+	Partition time.Time // time of the `YYYY/MM/DD/HH/mm` partition directory the block is in
+	Size      int64
+	Sidecars  []string // e.g. checksum files that belong to the block
+}
+
+// ListBlocks walks root and returns every block file inside a `YYYY/MM/DD/HH/mm` partition directory (see
+// `partitionPath`), sorted by destination directory, partition and name. Partitions are interpreted in loc (defaults
+// to `time.Local`, which is what jetcapture renders paths in). Hidden files (e.g. in-progress temporary files) are
+// ignored, and files named after a block plus DefaultChecksumSuffix are returned as its sidecars.
+func ListBlocks(root string, loc *time.Location) ([]StoredBlock, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+
+	var (
+		blocks   []StoredBlock
+		sidecars = map[string][]string{}
+	)
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, filepath.Dir(p))
+		if err != nil {
+			return err
+		}
+
+		destDir, partition, ok := parsePartition(rel, loc)
+		if !ok {
+			return nil
+		}
+
+		if strings.HasSuffix(p, DefaultChecksumSuffix) {
+			blockPath := strings.TrimSuffix(p, DefaultChecksumSuffix)
+			sidecars[blockPath] = append(sidecars[blockPath], p)
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		blocks = append(blocks, StoredBlock{
+			Path:      p,
+			DestDir:   destDir,
+			Partition: partition,
+			Size:      info.Size(),
+		})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range blocks {
+		blocks[i].Sidecars = sidecars[blocks[i].Path]
+	}
+
+	sort.SliceStable(blocks, func(i, j int) bool {
+		a, b := blocks[i], blocks[j]
+		if a.DestDir != b.DestDir {
+			return a.DestDir < b.DestDir
+		}
+		if !a.Partition.Equal(b.Partition) {
+			return a.Partition.Before(b.Partition)
+		}
+		return a.Path < b.Path
+	})
+
+	return blocks, nil
+}
+
+// parsePartition splits a directory (relative to a tree root) into the destination directory and the time of its
+// trailing `YYYY/MM/DD/HH/mm` components
+func parsePartition(dir string, loc *time.Location) (string, time.Time, bool) {
+	parts := strings.Split(filepath.ToSlash(dir), "/")
+	if len(parts) < 5 {
+		return _EMPTY_, time.Time{}, false
+	}
+
+	var v [5]int
+
+	for i, part := range parts[len(parts)-5:] {
+		want := 2
+		if i == 0 {
+			want = 4
+		}
+
+		if len(part) != want {
+			return _EMPTY_, time.Time{}, false
+		}
+
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return _EMPTY_, time.Time{}, false
+		}
+
+		v[i] = n
+	}
+
+	t := time.Date(v[0], time.Month(v[1]), v[2], v[3], v[4], 0, 0, loc)
+
+	// reject values time.Date would normalize, e.g. month 13
+	if t.Month() != time.Month(v[1]) || t.Day() != v[2] || t.Hour() != v[3] || t.Minute() != v[4] {
+		return _EMPTY_, time.Time{}, false
+	}
+
+	destDir := filepath.Join(parts[:len(parts)-5]...)
+	if destDir == _EMPTY_ {
+		destDir = "."
+	}
+
+	return destDir, t, true
+}
@@ -0,0 +1,198 @@
+package jetcapture
+
+import (
+	"fmt"
+	"time"
+)
+
+// Window assigns messages to the block intervals they are captured in
+type Window interface {
+	// Start returns the start of the window t falls into. Block paths are rendered in the location of the result.
+	Start(t time.Time) time.Time
+	// End returns the (exclusive) end of the window starting at start
+	End(start time.Time) time.Time
+}
+
+// EpochWindow truncates times to multiples of d since the zero time, which is what jetcapture does without a Window.
+// This aligns to UTC, and durations that don't evenly divide an hour or a day drift across them.
+func EpochWindow(d time.Duration) Window {
+	return epochWindow(d)
+}
+
+type epochWindow time.Duration
+
+func (w epochWindow) Start(t time.Time) time.Time {
+	return t.Truncate(time.Duration(w))
+}
+
+func (w epochWindow) End(start time.Time) time.Time {
+	return start.Add(time.Duration(w))
+}
+
+func (w epochWindow) String() string {
+	return time.Duration(w).String()
+}
+
+// FixedWindow splits each day in loc into windows of d, aligned to the wall clock starting at midnight. If d doesn't
+// evenly divide the day the last window of the day is shorter. On days with a DST change, the windows spanning the
+// change are longer or shorter by the change: a window whose wall clock start is skipped starts with the change, and
+// the repeated hour when DST ends has windows for both offsets, the first of which continues the window before the
+// change. d must be at most 24h.
+func FixedWindow(d time.Duration, loc *time.Location) (Window, error) {
+	if d <= 0 || d > 24*time.Hour {
+		return nil, fmt.Errorf("window duration %s must be positive and at most 24h", d)
+	}
+
+	if loc == nil {
+		loc = time.Local
+	}
+
+	return fixedWindow{d: d, loc: loc}, nil
+}
+
+type fixedWindow struct {
+	d   time.Duration
+	loc *time.Location
+}
+
+func (w fixedWindow) Start(t time.Time) time.Time {
+	t = t.In(w.loc)
+	wall := wallClock(t)
+
+	// subtract rather than use time.Date, which is ambiguous for the repeated hour when DST ends
+	start := t.Add(wall/w.d*w.d - wall)
+
+	change, _ := t.ZoneBounds()
+	if !start.Before(change) {
+		return start
+	}
+
+	// the start has the previous offset. if the change skipped over it, the window starts with the change
+	_, before := change.Add(-time.Nanosecond).Zone()
+	_, after := t.Zone()
+
+	if !start.Add(time.Duration(after-before) * time.Second).Before(change) {
+		return change
+	}
+
+	// otherwise, t is still in the last window before the change, which can start later than the wall clock suggests
+	// when DST ends and the clock is set back
+	return w.Start(change.Add(-time.Nanosecond))
+}
+
+func (w fixedWindow) End(start time.Time) time.Time {
+	start = start.In(w.loc)
+	wall := wallClock(start)
+
+	// the next window of the day, or midnight
+	next := start.Add(min((wall/w.d+1)*w.d, 24*time.Hour) - wall)
+
+	_, end := start.ZoneBounds()
+	if end.IsZero() || next.Before(end) {
+		return next
+	}
+
+	// the offset changes first, which either starts a window or moves the wall clock of the next one
+	if change := w.Start(end); change.Equal(end) {
+		return change
+	}
+
+	return w.End(end)
+}
+
+// wallClock returns the wall clock time of t since midnight, which isn't the elapsed time on days with a DST change
+func wallClock(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+}
+
+func (w fixedWindow) String() string {
+	return fmt.Sprintf("%s (%s)", w.d, w.loc)
+}
+
+// CalendarUnit is the length of a CalendarWindow
+type CalendarUnit string
+
+const (
+	CalendarHour  CalendarUnit = "hour"
+	CalendarDay   CalendarUnit = "day"
+	CalendarWeek  CalendarUnit = "week" // starting on Monday
+	CalendarMonth CalendarUnit = "month"
+)
+
+// CalendarWindow aligns windows to calendar hours, days, weeks or months in loc. Days, weeks and months start at local
+// midnight, so they can be 23 or 25 hours longer or shorter around DST changes.
+func CalendarWindow(unit CalendarUnit, loc *time.Location) (Window, error) {
+	switch unit {
+	case CalendarHour, CalendarDay, CalendarWeek, CalendarMonth:
+	default:
+		return nil, fmt.Errorf("unknown calendar unit %q", unit)
+	}
+
+	if loc == nil {
+		loc = time.Local
+	}
+
+	return calendarWindow{unit: unit, loc: loc}, nil
+}
+
+type calendarWindow struct {
+	unit CalendarUnit
+	loc  *time.Location
+}
+
+func (w calendarWindow) Start(t time.Time) time.Time {
+	t = t.In(w.loc)
+
+	switch w.unit {
+	case CalendarHour:
+		// subtract rather than use time.Date, which is ambiguous for the repeated hour when DST ends
+		return t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second -
+			time.Duration(t.Nanosecond()))
+	case CalendarWeek:
+		day := startOfDay(t)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case CalendarMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, w.loc)
+	default:
+		return startOfDay(t)
+	}
+}
+
+func (w calendarWindow) End(start time.Time) time.Time {
+	switch w.unit {
+	case CalendarHour:
+		return start.Add(time.Hour)
+	case CalendarWeek:
+		return start.AddDate(0, 0, 7)
+	case CalendarMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+func (w calendarWindow) String() string {
+	return fmt.Sprintf("%s (%s)", w.unit, w.loc)
+}
+
+// ParseWindow parses `hour`, `day`, `week`, `month` or a duration (e.g. `7m`) into a window in loc. Durations are
+// aligned to midnight, see FixedWindow.
+func ParseWindow(s string, loc *time.Location) (Window, error) {
+	switch unit := CalendarUnit(s); unit {
+	case CalendarHour, CalendarDay, CalendarWeek, CalendarMonth:
+		return CalendarWindow(unit, loc)
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid window %q: use hour, day, week, month or a duration", s)
+	}
+
+	return FixedWindow(d, loc)
+}
+
+// startOfDay returns midnight of the day of t in its location
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
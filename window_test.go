@@ -0,0 +1,150 @@
+package jetcapture
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWindows(t *testing.T) {
+	assert := require.New(t)
+
+	oslo, err := time.LoadLocation("Europe/Oslo")
+	assert.Nil(err)
+
+	at := func(v string) time.Time {
+		ts, err := time.Parse(time.RFC3339, v)
+		assert.Nil(err)
+		return ts
+	}
+
+	mustWindow := func(w Window, err error) Window {
+		assert.Nil(err)
+		return w
+	}
+
+	tests := []struct {
+		name   string
+		window Window
+		t      string
+		start  string
+		end    string
+		path   string
+	}{
+		{"epoch", EpochWindow(7 * time.Minute), "2024-01-02T03:05:05Z", "2024-01-02T03:04:00Z", "2024-01-02T03:11:00Z", "2024/01/02/03/04/"},
+		{"fixed", mustWindow(FixedWindow(7*time.Minute, oslo)), "2024-01-02T03:04:05Z", "2024-01-02T03:58:00+01:00", "2024-01-02T04:05:00+01:00", "2024/01/02/03/58/"},
+		{"fixed last of day", mustWindow(FixedWindow(7*time.Minute, oslo)), "2024-01-02T22:59:00Z", "2024-01-02T23:55:00+01:00", "2024-01-03T00:00:00+01:00", "2024/01/02/23/55/"},
+		{"hour", mustWindow(CalendarWindow(CalendarHour, oslo)), "2024-01-02T03:04:05Z", "2024-01-02T04:00:00+01:00", "2024-01-02T05:00:00+01:00", "2024/01/02/04/00/"},
+		{"repeated hour summer", mustWindow(CalendarWindow(CalendarHour, oslo)), "2024-10-27T00:30:00Z", "2024-10-27T02:00:00+02:00", "2024-10-27T02:00:00+01:00", "2024/10/27/02/00/"},
+		{"repeated hour winter", mustWindow(CalendarWindow(CalendarHour, oslo)), "2024-10-27T01:30:00Z", "2024-10-27T02:00:00+01:00", "2024-10-27T03:00:00+01:00", "2024/10/27/02/00/"},
+		{"day", mustWindow(CalendarWindow(CalendarDay, oslo)), "2024-01-01T23:30:00Z", "2024-01-02T00:00:00+01:00", "2024-01-03T00:00:00+01:00", "2024/01/02/00/00/"},
+		{"short day", mustWindow(CalendarWindow(CalendarDay, oslo)), "2024-03-31T12:00:00Z", "2024-03-31T00:00:00+01:00", "2024-04-01T00:00:00+02:00", "2024/03/31/00/00/"},
+		{"long day", mustWindow(CalendarWindow(CalendarDay, oslo)), "2024-10-27T12:00:00Z", "2024-10-27T00:00:00+02:00", "2024-10-28T00:00:00+01:00", "2024/10/27/00/00/"},
+		{"week", mustWindow(CalendarWindow(CalendarWeek, oslo)), "2024-01-07T22:59:00Z", "2024-01-01T00:00:00+01:00", "2024-01-08T00:00:00+01:00", "2024/01/01/00/00/"},
+		{"month", mustWindow(CalendarWindow(CalendarMonth, oslo)), "2024-02-29T23:30:00Z", "2024-03-01T00:00:00+01:00", "2024-04-01T00:00:00+02:00", "2024/03/01/00/00/"},
+	}
+
+	for _, tt := range tests {
+		start := tt.window.Start(at(tt.t))
+		assert.True(start.Equal(at(tt.start)), "%s: start %s", tt.name, start)
+
+		end := tt.window.End(start)
+		assert.True(end.Equal(at(tt.end)), "%s: end %s", tt.name, end)
+
+		assert.Equal(tt.path, partitionPath(start), tt.name)
+	}
+
+	day := mustWindow(CalendarWindow(CalendarDay, oslo))
+
+	for v, hours := range map[string]time.Duration{"2024-03-31T12:00:00Z": 23, "2024-10-27T12:00:00Z": 25} {
+		start := day.Start(at(v))
+		assert.Equal(hours*time.Hour, day.End(start).Sub(start), v)
+	}
+
+	// fixed windows keep to the wall clock on DST days
+	for _, tt := range []struct {
+		day    string
+		window time.Duration
+		starts []string
+	}{
+		{"2024-10-27T00:00:00+02:00", 6 * time.Hour, []string{"00:00+02:00", "06:00+01:00", "12:00+01:00", "18:00+01:00"}},
+		{"2024-03-31T00:00:00+01:00", 6 * time.Hour, []string{"00:00+01:00", "06:00+02:00", "12:00+02:00", "18:00+02:00"}},
+		// 02:30 doesn't exist, so that window starts with the change at 03:00
+		{"2024-03-31T00:00:00+01:00", 150 * time.Minute, []string{"00:00+01:00", "03:00+02:00", "05:00+02:00", "07:30+02:00",
+			"10:00+02:00", "12:30+02:00", "15:00+02:00", "17:30+02:00", "20:00+02:00", "22:30+02:00"}},
+		// the repeated hour has windows for both offsets
+		{"2024-10-27T01:00:00+02:00", 30 * time.Minute, []string{"01:00+02:00", "01:30+02:00", "02:00+02:00", "02:30+02:00",
+			"02:00+01:00", "02:30+01:00", "03:00+01:00", "03:30+01:00"}},
+	} {
+		fixed := mustWindow(FixedWindow(tt.window, oslo))
+
+		var starts []string
+		for start := at(tt.day); len(starts) < len(tt.starts); start = fixed.End(start) {
+			assert.True(fixed.Start(start).Equal(start), "%s: %s", tt.day, start)
+			assert.True(fixed.Start(fixed.End(start).Add(-time.Nanosecond)).Equal(start), "%s: %s", tt.day, start)
+			starts = append(starts, start.In(oslo).Format("15:04Z07:00"))
+		}
+
+		assert.Equal(tt.starts, starts, tt.day)
+	}
+
+	w, err := ParseWindow("day", oslo)
+	assert.Nil(err)
+	assert.Equal(tests[6].window, w)
+
+	w, err = ParseWindow("7m", oslo)
+	assert.Nil(err)
+	assert.Equal(tests[1].window, w)
+
+	for _, invalid := range []string{"fortnight", "0s", "25h"} {
+		_, err = ParseWindow(invalid, oslo)
+		assert.NotNil(err, invalid)
+	}
+}
+
+func TestFixedWindowDST(t *testing.T) {
+	assert := require.New(t)
+
+	oslo, err := time.LoadLocation("Europe/Oslo")
+	assert.Nil(err)
+
+	// windows that don't divide an hour evenly start at odd wall clock times around the change
+	for _, d := range []time.Duration{7 * time.Minute, 9 * time.Minute, 30 * time.Minute, 150 * time.Minute} {
+		w, err := FixedWindow(d, oslo)
+		assert.Nil(err)
+
+		for _, day := range []time.Time{
+			time.Date(2024, 3, 30, 22, 0, 0, 0, time.UTC),
+			time.Date(2024, 10, 26, 21, 0, 0, 0, time.UTC),
+		} {
+			var prev time.Time
+
+			for ts := day; ts.Before(day.Add(26 * time.Hour)); ts = ts.Add(30 * time.Second) {
+				start := w.Start(ts)
+				end := w.End(start)
+
+				assert.False(start.After(ts), "%s %s: start %s", d, ts, start)
+				assert.True(end.After(ts), "%s %s: end %s of %s", d, ts, end, start)
+				assert.False(start.Before(prev), "%s %s: start %s before %s", d, ts, start, prev)
+				assert.True(w.Start(start).Equal(start), "%s %s: start %s", d, ts, start)
+
+				prev = start
+			}
+		}
+	}
+
+	// the start of the repeated hour belongs to the window spanning the change
+	w, err := FixedWindow(7*time.Minute, oslo)
+	assert.Nil(err)
+
+	for _, ts := range []time.Time{
+		time.Date(2024, 10, 27, 0, 55, 0, 0, time.UTC),
+		time.Date(2024, 10, 27, 1, 0, 0, 0, time.UTC),
+		time.Date(2024, 10, 27, 1, 5, 0, 0, time.UTC),
+	} {
+		start := w.Start(ts)
+		assert.Equal("2024-10-27T02:55:00+02:00", start.Format(time.RFC3339), ts)
+		assert.Equal("2024-10-27T02:06:00+01:00", w.End(start).Format(time.RFC3339), ts)
+	}
+}